@@ -0,0 +1,105 @@
+package v1beta1
+
+import (
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BuildTemplateSpec defines the recipe to build websites shared by WebSites.
+type BuildTemplateSpec struct {
+	// BuildImage is a container image name that will be used to build the website
+	// +optional
+	BuildImage string `json:"buildImage,omitempty"`
+
+	// BuildScript is a script to build the website
+	// +optional
+	BuildScript *DataSource `json:"buildScript,omitempty"`
+
+	// AfterBuildScript is a script to execute in Job once after build
+	// +optional
+	AfterBuildScript *DataSource `json:"afterBuildScript,omitempty"`
+
+	// NginxConf is a configuration file for nginx.
+	// +optional
+	NginxConf *DataSource `json:"nginxConf,omitempty"`
+
+	// Parameters are the parameters that WebSites can give to the scripts.
+	// They are passed to the build and the after build scripts as environment variables.
+	// +optional
+	Parameters []BuildTemplateParameter `json:"parameters,omitempty"`
+}
+
+// BuildTemplateParameter defines a parameter of BuildTemplate.
+type BuildTemplateParameter struct {
+	// Name is the name of the environment variable to pass the parameter.
+	// It cannot be the name of the environment variables given by website-operator, such as REVISION, OUTPUT and GIT_CONFIG_*.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+
+	// Type is the type of the parameter
+	// +kubebuilder:validation:Enum=string;integer;boolean
+	// +kubebuilder:default=string
+	// +optional
+	Type ParameterType `json:"type,omitempty"`
+
+	// Description is the description of the parameter
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Default is the value used if the WebSite does not give the parameter
+	// +optional
+	Default *string `json:"default,omitempty"`
+
+	// Required makes the WebSites give the parameter
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// reservedParameterNames are the environment variables that website-operator passes to the build.
+var reservedParameterNames = []string{
+	"HOME", "REVISION", "OUTPUT", "BUILD_TIMEOUT", "PR_REF",
+	"RESOURCE_NAMESPACE", "RESOURCE_NAME", "REPO_URL", "REPO_NAME", "REPO_BRANCH",
+	"ARTIFACT", "DEPLOYED_ARTIFACT", "RETAINED_ARTIFACTS",
+	"BUILD_CACHE", "XDG_CACHE_HOME", "npm_config_cache", "GOMODCACHE", "PURGE_TOKEN",
+}
+
+// IsReservedParameterName returns true if the parameter would shadow an environment variable given by website-operator.
+func IsReservedParameterName(name string) bool {
+	return slices.Contains(reservedParameterNames, name) || strings.HasPrefix(name, "GIT_CONFIG_")
+}
+
+// ParameterType is the type of the parameter of BuildTemplate.
+type ParameterType string
+
+const (
+	ParameterTypeString  = ParameterType("string")
+	ParameterTypeInteger = ParameterType("integer")
+	ParameterTypeBoolean = ParameterType("boolean")
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="IMAGE",type="string",JSONPath=".spec.buildImage"
+
+// BuildTemplate is the Schema for the buildtemplates API
+type BuildTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BuildTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BuildTemplateList contains a list of BuildTemplate
+type BuildTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BuildTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BuildTemplate{}, &BuildTemplateList{})
+}
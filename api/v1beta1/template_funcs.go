@@ -0,0 +1,81 @@
+package v1beta1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ExtraResourceFuncMap returns the functions available in the templates of ExtraResources.
+// They behave like the functions of the same names in Sprig.
+func ExtraResourceFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"default": defaultValue,
+		"quote":   quote,
+		"b64enc":  b64enc,
+		"toYaml":  toYaml,
+		"indent":  indent,
+		"nindent": nindent,
+	}
+}
+
+// defaultValue returns def if v is empty.
+// It is called as `{{ .Value | default "foo" }}`.
+func defaultValue(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+func quote(v ...interface{}) string {
+	quoted := make([]string, 0, len(v))
+	for _, s := range v {
+		if s == nil {
+			continue
+		}
+		quoted = append(quoted, fmt.Sprintf("%q", fmt.Sprint(s)))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func b64enc(v interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v)))
+}
+
+// toYaml returns v in YAML without the trailing newline, so that it can be piped to indent.
+func toYaml(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
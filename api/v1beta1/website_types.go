@@ -2,6 +2,7 @@ package v1beta1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -13,18 +14,29 @@ type WebSiteSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// BuildImage is a container image name that will be used to build the website
-	// +kubebuiler:validation:Required
-	BuildImage string `json:"buildImage"`
+	// BuildImage is a container image name that will be used to build the website.
+	// It is required unless BuildTemplate provides it.
+	// +optional
+	BuildImage string `json:"buildImage,omitempty"`
 
-	// BuildScript is a script to build the website
-	// +kubebuiler:validation:Required
-	BuildScript DataSource `json:"buildScript"`
+	// BuildScript is a script to build the website.
+	// It is required unless BuildTemplate provides it.
+	// +optional
+	BuildScript DataSource `json:"buildScript,omitempty"`
+
+	// BuildTemplate refers to the BuildTemplate that provides the build image, the scripts and nginx.conf.
+	// The fields specified in the WebSite take precedence over the ones of the BuildTemplate.
+	// +optional
+	BuildTemplate *BuildTemplateRef `json:"buildTemplate,omitempty"`
 
 	// BuildSecrets is the list of secrets you can use in a build script
 	// +optional
 	BuildSecrets []SecretKey `json:"buildSecrets,omitempty"`
 
+	// Build configures the resources, the timeout and the retries of the build
+	// +optional
+	Build *BuildSpec `json:"build,omitempty"`
+
 	// ImagePullSecrets is a list of references to secrets in the same namespace to use for pulling the images (buildImage, nginx and repo-checker).
 	// +optional
 	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
@@ -38,10 +50,54 @@ type WebSiteSpec struct {
 	// +optional
 	Branch string `json:"branch"`
 
+	// Tag is the name of the tag or the semver constraint such as ">=2.0.0 <3" to deploy.
+	// If a tag matches it exactly, the tag is deployed. Otherwise, the highest version that satisfies the constraint is deployed.
+	// If specified, Branch is ignored.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Revision is the commit hash to deploy.
+	// If specified, the site is pinned to the revision and Branch and Tag are not tracked.
+	// To roll back, set one of the revisions in Status.History.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// PollInterval is the interval for repo-checker to check the repository.
+	// A random delay up to 10% of the interval is added so that many sites do not access the Git server at the same time.
+	// If omitted, the default interval of repo-checker (10 minutes) is used.
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+
+	// RevisionHistoryLimit is the number of deployed revisions to be kept in Status.History.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
 	// DeployKeySecretName is the name of the secret resource that contains the deploy key to access the private repository
 	// +optional
 	DeployKeySecretName *string `json:"deployKeySecretName,omitempty"`
 
+	// InsecureIgnoreHostKey makes repo-checker skip the verification of the host key of the Git server
+	// if the secret of DeployKeySecretName does not contain `known_hosts`.
+	// +optional
+	InsecureIgnoreHostKey bool `json:"insecureIgnoreHostKey,omitempty"`
+
+	// GitAuth is the credentials to access the private repository over HTTPS.
+	// It is used by repo-checker, the build and the after build Job.
+	// +optional
+	GitAuth *GitAuth `json:"gitAuth,omitempty"`
+
+	// WebhookSecret is the secret key used to verify push events sent to the webhook endpoint of repo-checker.
+	// The webhook endpoint is enabled only if this is specified.
+	// +optional
+	WebhookSecret *SecretKey `json:"webhookSecret,omitempty"`
+
+	// DedicatedRepoChecker runs a repo-checker for this site even if the operator uses the shared repo-checker.
+	// The sites that have DeployKeySecretName or WebhookSecret always have their own repo-checker.
+	// +optional
+	DedicatedRepoChecker bool `json:"dedicatedRepoChecker,omitempty"`
+
 	// ExtraResources are resources that will be applied after the build step
 	// +optional
 	ExtraResources []DataSource `json:"extraResources,omitempty"`
@@ -51,6 +107,15 @@ type WebSiteSpec struct {
 	// +optional
 	Replicas int32 `json:"replicas,omitempty"`
 
+	// Strategy is the strategy to roll out a new revision.
+	// RollingUpdate updates the nginx Deployment in place.
+	// BlueGreen deploys the new revision to another Deployment, and switches the Service only after it becomes ready.
+	// If the new revision does not become ready, the previous revision continues to be served.
+	// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Strategy RolloutStrategy `json:"strategy,omitempty"`
+
 	// PodTemplate is a `Pod` template for nginx container.
 	// +optional
 	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
@@ -71,9 +136,303 @@ type WebSiteSpec struct {
 	// +optional
 	AfterBuildScript *DataSource `json:"afterBuildScript"`
 
+	// AfterBuild configures the resources, the deadline and the retries of the after-build Job
+	// +optional
+	AfterBuild *AfterBuildSpec `json:"afterBuild,omitempty"`
+
 	// PublicURL is the URL of the website
 	// +optional
 	PublicURL string `json:"publicURL,omitempty"`
+
+	// Ingress creates an Ingress that routes the host and the path of PublicURL to nginx.
+	// Only one of Ingress and HTTPRoute may be specified.
+	// +optional
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+
+	// HTTPRoute creates a Gateway API HTTPRoute that routes the host and the path of PublicURL to nginx.
+	// Only one of Ingress and HTTPRoute may be specified.
+	// +optional
+	HTTPRoute *HTTPRouteSpec `json:"httpRoute,omitempty"`
+
+	// Artifacts is the storage for the build output.
+	// If specified, the website is built once per revision by a Job and nginx only fetches the built output.
+	// +optional
+	Artifacts *ArtifactsStorage `json:"artifacts,omitempty"`
+
+	// BuildCache is the storage that keeps the caches of the dependencies between the builds.
+	// It is available only with Artifacts, so that the builds do not share the cache at the same time.
+	// +optional
+	BuildCache *BuildCacheStorage `json:"buildCache,omitempty"`
+
+	// Previews creates a preview WebSite for each open pull request of the repository.
+	// +optional
+	Previews *PreviewsSpec `json:"previews,omitempty"`
+}
+
+// BuildTemplateRef refers to a BuildTemplate.
+type BuildTemplateRef struct {
+	// Name is the name of the BuildTemplate
+	Name string `json:"name"`
+
+	// Parameters are the values of the parameters of the BuildTemplate
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// BuildSpec defines how to run the build.
+type BuildSpec struct {
+	// Resources are the compute resources of the build container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Timeout is the maximum duration of the build. The build is killed if it does not finish in time.
+	// If Artifacts is specified, the timeout is the deadline of the build Job including the retries.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// BackoffLimit is the number of the retries of the build Job. It is used only if Artifacts is specified.
+	// The default is the one of Kubernetes.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+}
+
+// AfterBuildSpec defines how to run the after-build Job.
+type AfterBuildSpec struct {
+	// Resources are the compute resources of the after-build container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// BackoffLimit is the number of the retries of the after-build Job.
+	// The default is the one of Kubernetes.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// ActiveDeadlineSeconds is the deadline of the after-build Job including the retries
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// PreviewsSpec defines the preview environments for pull requests.
+type PreviewsSpec struct {
+	// Host is a template of the host name of each preview such as "pr-{{ .Number }}.example.com".
+	// `.Number`, `.ResourceName` and `.ResourceNamespace` are available in the template.
+	Host string `json:"host"`
+
+	// Limit is the maximum number of previews. Previews are created for the newest pull requests.
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Limit int32 `json:"limit,omitempty"`
+
+	// MaxAge is the duration to keep a preview after the last commit of its pull request.
+	// GitHub and Gitea keep the refs of closed pull requests, so their previews are removed when they get older than MaxAge.
+	// It is not applied if repo-checker cannot fetch the timestamps of the commits.
+	// If omitted, the previews are kept for 14 days.
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// InheritSecrets passes BuildSecrets, DeployKeySecretName and GitAuth of the parent to the previews.
+	// The build of a preview runs the code of the pull request, so anyone who can open a pull request can read them.
+	// Enable it only if the pull requests are limited to trusted users.
+	// +optional
+	InheritSecrets bool `json:"inheritSecrets,omitempty"`
+}
+
+// IngressSpec defines the Ingress for the website.
+type IngressSpec struct {
+	// IngressClassName is the name of the IngressClass
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// TLSSecretName is the name of the secret resource that contains the TLS certificate for the host
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// Annotations are the annotations of the Ingress
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// HTTPRouteSpec defines the Gateway API HTTPRoute for the website.
+type HTTPRouteSpec struct {
+	// ParentRefs are the Gateways that the HTTPRoute attaches to
+	// +kubebuilder:validation:MinItems=1
+	ParentRefs []ParentReference `json:"parentRefs"`
+
+	// Annotations are the annotations of the HTTPRoute
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ParentReference identifies a Gateway.
+type ParentReference struct {
+	// Name is the name of the Gateway
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Gateway.
+	// if omitted, it will be the same namespace as the WebSite resource
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName is the name of the listener of the Gateway
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// RolloutStrategy is the strategy to roll out a new revision.
+type RolloutStrategy string
+
+const (
+	RollingUpdateStrategy = RolloutStrategy("RollingUpdate")
+	BlueGreenStrategy     = RolloutStrategy("BlueGreen")
+)
+
+// ArtifactsStorage defines the PersistentVolumeClaim that stores the build output.
+type ArtifactsStorage struct {
+	// StorageClassName is the name of the StorageClass for the PersistentVolumeClaim
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Size is the size of the PersistentVolumeClaim
+	// +kubebuilder:default="1Gi"
+	// +optional
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// AccessModes are the access modes of the PersistentVolumeClaim.
+	// ReadWriteMany is required if nginx instances can be scheduled to several nodes.
+	// +kubebuilder:default={"ReadWriteMany"}
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// Retention is the number of the artifacts kept in the storage, the most recently used first.
+	// A revision built with the same build script is served from the kept artifact without building it again.
+	// The artifact being served is always kept.
+	// +kubebuilder:default=2
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Retention int32 `json:"retention,omitempty"`
+}
+
+// BuildCacheStorage defines the PersistentVolumeClaim that keeps the caches of the dependencies between the builds.
+type BuildCacheStorage struct {
+	// StorageClassName is the name of the StorageClass for the PersistentVolumeClaim
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Size is the size of the PersistentVolumeClaim
+	// +kubebuilder:default="5Gi"
+	// +optional
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// AccessModes are the access modes of the PersistentVolumeClaim.
+	// ReadWriteMany is required if the builds can be scheduled to several nodes.
+	// +kubebuilder:default={"ReadWriteMany"}
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// ArtifactStatus represents an artifact kept in the storage.
+type ArtifactStatus struct {
+	// Name is the name of the artifact derived from the revision and the hash of the build script
+	Name string `json:"name"`
+
+	// Revision is the revision built into the artifact
+	Revision string `json:"revision"`
+
+	// BuiltAt is the time when the build of the artifact was completed
+	BuiltAt metav1.Time `json:"builtAt"`
+}
+
+// ExtraResourceRef refers to an extra resource applied for the WebSite.
+type ExtraResourceRef struct {
+	// APIVersion is the API version of the resource
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the kind of the resource
+	Kind string `json:"kind"`
+
+	// Name is the name of the resource
+	Name string `json:"name"`
+}
+
+// RevisionHistory represents a revision deployed in the past.
+type RevisionHistory struct {
+	// Revision is the commit hash
+	Revision string `json:"revision"`
+
+	// Tag is the name of the tag that points to Revision
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// DeployedAt is the time when the revision was deployed
+	DeployedAt metav1.Time `json:"deployedAt"`
+}
+
+// CommitInfo represents the metadata of a commit.
+type CommitInfo struct {
+	// Author is the name of the author of the commit
+	// +optional
+	Author string `json:"author,omitempty"`
+
+	// Message is the first line of the commit message
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Timestamp is the time when the commit was committed
+	// +optional
+	Timestamp *metav1.Time `json:"timestamp,omitempty"`
+}
+
+// PreviewStatus represents a preview WebSite of a pull request.
+type PreviewStatus struct {
+	// Number is the number of the pull request
+	Number int `json:"number"`
+
+	// Name is the name of the preview WebSite
+	Name string `json:"name"`
+
+	// Revision is the head revision of the pull request
+	Revision string `json:"revision"`
+
+	// URL is the public URL of the preview
+	URL string `json:"url"`
+}
+
+// GitAuth represents the credentials to access the repository over HTTPS.
+// Only one of its members may be specified.
+type GitAuth struct {
+	// SecretName is the name of the secret resource that contains `password` and optionally `username`.
+	// `password` may be an access token.
+	// +optional
+	SecretName *string `json:"secretName,omitempty"`
+
+	// GitHubApp is the GitHub App installed to the repository.
+	// The operator mints short-lived installation tokens of the app.
+	// +optional
+	GitHubApp *GitHubAppAuth `json:"githubApp,omitempty"`
+}
+
+// GitHubAppAuth represents a GitHub App to access the repository.
+type GitHubAppAuth struct {
+	// AppID is the ID of the GitHub App
+	// +kubebuilder:validation:Minimum=1
+	AppID int64 `json:"appID"`
+
+	// InstallationID is the ID of the installation of the GitHub App
+	// +kubebuilder:validation:Minimum=1
+	InstallationID int64 `json:"installationID"`
+
+	// PrivateKeySecretName is the name of the secret resource that contains the private key of the GitHub App in `privateKey`
+	PrivateKeySecretName string `json:"privateKeySecretName"`
+
+	// APIURL is the URL of the GitHub API. Specify it for GitHub Enterprise Server.
+	// It should be one of the URLs allowed by `--allowed-github-api-urls` flag of website-operator.
+	// +kubebuilder:default="https://api.github.com"
+	// +optional
+	APIURL string `json:"apiURL,omitempty"`
 }
 
 // SecretKey represents the name and key of a secret resource.
@@ -143,15 +502,79 @@ type WebSiteStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// Revision is a revision currently available to the public
-	Revision string `json:"revision"`
-	// Ready is the current status
-	Ready corev1.ConditionStatus `json:"ready"`
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Tag is the name of the tag that points to Revision. It is set only if Spec.Tag is specified
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Commit is the metadata of the commit of Revision. It is set only if repo-checker has fetched it
+	// +optional
+	Commit *CommitInfo `json:"commit,omitempty"`
+
+	// History is the list of the revisions deployed so far, the newest first
+	// +optional
+	History []RevisionHistory `json:"history,omitempty"`
+
+	// Artifacts are the artifacts kept in the storage of spec.artifacts, the most recently used first
+	// +optional
+	Artifacts []ArtifactStatus `json:"artifacts,omitempty"`
+
+	// ExtraResources are the extra resources applied for the WebSite.
+	// The ones no longer rendered from spec.extraResources are deleted.
+	// +optional
+	ExtraResources []ExtraResourceRef `json:"extraResources,omitempty"`
+
+	// Address is the address admitted to the Ingress or the HTTPRoute
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// ActiveColor is the color of the nginx Deployment that serves the site. It is set only for the BlueGreen strategy
+	// +optional
+	ActiveColor string `json:"activeColor,omitempty"`
+
+	// Previews are the preview WebSites created for open pull requests
+	// +optional
+	Previews []PreviewStatus `json:"previews,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the WebSite's state
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+const (
+	// ConditionReady indicates that all the other conditions are true.
+	ConditionReady = "Ready"
+	// ConditionRevisionResolved indicates that the revision to deploy has been obtained from repo-checker.
+	ConditionRevisionResolved = "RevisionResolved"
+	// ConditionConfigRendered indicates that the build scripts and nginx.conf have been rendered into ConfigMaps.
+	ConditionConfigRendered = "ConfigRendered"
+	// ConditionBuildSucceeded indicates that the site has been built for the current revision.
+	ConditionBuildSucceeded = "BuildSucceeded"
+	// ConditionNginxAvailable indicates that nginx is available to serve the site.
+	ConditionNginxAvailable = "NginxAvailable"
+	// ConditionAfterBuildCompleted indicates that the after-build Job has completed for the current revision.
+	ConditionAfterBuildCompleted = "AfterBuildCompleted"
+	// ConditionExtraResourcesApplied indicates that all the extra resources have been applied.
+	ConditionExtraResourcesApplied = "ExtraResourcesApplied"
+	// ConditionRolloutSucceeded indicates that the current revision has been rolled out by the BlueGreen strategy.
+	// It is not taken into account for ConditionReady because the previous revision is served while rolling out.
+	ConditionRolloutSucceeded = "RolloutSucceeded"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
-//+kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.ready"
+//+kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
 //+kubebuilder:printcolumn:name="REVISION",type="string",JSONPath=".status.revision"
+//+kubebuilder:printcolumn:name="TAG",type="string",JSONPath=".status.tag",priority=1
+//+kubebuilder:printcolumn:name="ADDRESS",type="string",JSONPath=".status.address",priority=1
 
 // WebSite is the Schema for the websites API
 type WebSite struct {
@@ -0,0 +1,296 @@
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// VolumeTemplateNames are the names of the volumes that can be replaced by VolumeTemplates.
+var VolumeTemplateNames = []string{"data", "log", "cache", "tmp", "home"}
+
+// MinPollInterval is the minimum interval for repo-checker to check the repository.
+const MinPollInterval = 10 * time.Second
+
+// repoURLSchemes are the URL schemes that git can clone.
+var repoURLSchemes = []string{"https", "http", "ssh", "git", "file"}
+
+// scpLikeURL matches the scp-like syntax of git such as git@github.com:org/repo.git
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/].*$`)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks for WebSite.
+func (r *WebSite) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&webSiteDefaulter{}).
+		WithValidator(&webSiteValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-website-zoetrope-github-io-v1beta1-website,mutating=true,failurePolicy=fail,sideEffects=None,groups=website.zoetrope.github.io,resources=websites,verbs=create;update,versions=v1beta1,name=mwebsite.kb.io,admissionReviewVersions=v1
+
+type webSiteDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &webSiteDefaulter{}
+
+// Default implements webhook.CustomDefaulter
+func (d *webSiteDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*WebSite)
+	if !ok {
+		return fmt.Errorf("expected a WebSite but got a %T", obj)
+	}
+
+	if len(r.Spec.Branch) == 0 {
+		r.Spec.Branch = "main"
+	}
+	if r.Spec.Replicas == 0 {
+		r.Spec.Replicas = 1
+	}
+	if len(r.Spec.Strategy) == 0 {
+		r.Spec.Strategy = RollingUpdateStrategy
+	}
+	if r.Spec.RevisionHistoryLimit == nil {
+		r.Spec.RevisionHistoryLimit = ptr.To[int32](10)
+	}
+	if r.Spec.Artifacts != nil {
+		if len(r.Spec.Artifacts.AccessModes) == 0 {
+			r.Spec.Artifacts.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+		}
+		if r.Spec.Artifacts.Size.IsZero() {
+			r.Spec.Artifacts.Size = resource.MustParse("1Gi")
+		}
+		if r.Spec.Artifacts.Retention == 0 {
+			r.Spec.Artifacts.Retention = 2
+		}
+	}
+	if r.Spec.BuildCache != nil {
+		if len(r.Spec.BuildCache.AccessModes) == 0 {
+			r.Spec.BuildCache.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+		}
+		if r.Spec.BuildCache.Size.IsZero() {
+			r.Spec.BuildCache.Size = resource.MustParse("5Gi")
+		}
+	}
+	if r.Spec.Previews != nil && r.Spec.Previews.Limit == 0 {
+		r.Spec.Previews.Limit = 5
+	}
+	if r.Spec.GitAuth != nil && r.Spec.GitAuth.GitHubApp != nil && len(r.Spec.GitAuth.GitHubApp.APIURL) == 0 {
+		r.Spec.GitAuth.GitHubApp.APIURL = "https://api.github.com"
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-website-zoetrope-github-io-v1beta1-website,mutating=false,failurePolicy=fail,sideEffects=None,groups=website.zoetrope.github.io,resources=websites,verbs=create;update,versions=v1beta1,name=vwebsite.kb.io,admissionReviewVersions=v1
+
+type webSiteValidator struct{}
+
+var _ webhook.CustomValidator = &webSiteValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *webSiteValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	r, ok := obj.(*WebSite)
+	if !ok {
+		return nil, fmt.Errorf("expected a WebSite but got a %T", obj)
+	}
+	return nil, r.validate()
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (v *webSiteValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	r, ok := newObj.(*WebSite)
+	if !ok {
+		return nil, fmt.Errorf("expected a WebSite but got a %T", newObj)
+	}
+	old, ok := oldObj.(*WebSite)
+	if !ok {
+		return nil, fmt.Errorf("expected a WebSite but got a %T", oldObj)
+	}
+
+	var warnings admission.Warnings
+	if old.Spec.Artifacts != nil && r.Spec.Artifacts != nil && !equality.Semantic.DeepEqual(old.Spec.Artifacts, r.Spec.Artifacts) {
+		warnings = append(warnings, "spec.artifacts is used only when the PersistentVolumeClaim is created")
+	}
+	if old.Spec.BuildCache != nil && r.Spec.BuildCache != nil && !equality.Semantic.DeepEqual(old.Spec.BuildCache, r.Spec.BuildCache) {
+		warnings = append(warnings, "spec.buildCache is used only when the PersistentVolumeClaim is created")
+	}
+	return warnings, r.validate()
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *webSiteValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *WebSite) validate() error {
+	var errs field.ErrorList
+	p := field.NewPath("spec")
+
+	errs = append(errs, validateRepoURL(r.Spec.RepoURL, p.Child("repoURL"))...)
+	if r.Spec.BuildTemplate == nil {
+		if len(r.Spec.BuildImage) == 0 {
+			errs = append(errs, field.Required(p.Child("buildImage"), "buildImage is required unless buildTemplate is specified"))
+		}
+		errs = append(errs, validateDataSource(&r.Spec.BuildScript, p.Child("buildScript"))...)
+	} else {
+		if len(r.Spec.BuildTemplate.Name) == 0 {
+			errs = append(errs, field.Required(p.Child("buildTemplate", "name"), ""))
+		}
+		for _, name := range slices.Sorted(maps.Keys(r.Spec.BuildTemplate.Parameters)) {
+			if IsReservedParameterName(name) {
+				errs = append(errs, field.Invalid(p.Child("buildTemplate", "parameters").Key(name), name, "is reserved by website-operator"))
+			}
+		}
+		// the build script of the template is overridden only if specified
+		if r.Spec.BuildScript.ConfigMap != nil || r.Spec.BuildScript.RawData != nil {
+			errs = append(errs, validateDataSource(&r.Spec.BuildScript, p.Child("buildScript"))...)
+		}
+	}
+	if r.Spec.BuildScript.RawData != nil && len(*r.Spec.BuildScript.RawData) == 0 {
+		errs = append(errs, field.Required(p.Child("buildScript", "rawData"), "build script should not be empty"))
+	}
+	if r.Spec.AfterBuildScript != nil {
+		errs = append(errs, validateDataSource(r.Spec.AfterBuildScript, p.Child("afterBuildScript"))...)
+	}
+	if r.Spec.NginxConf != nil {
+		errs = append(errs, validateDataSource(r.Spec.NginxConf, p.Child("nginxConf"))...)
+	}
+	for i := range r.Spec.ExtraResources {
+		res := &r.Spec.ExtraResources[i]
+		errs = append(errs, validateDataSource(res, p.Child("extraResources").Index(i))...)
+		if res.RawData != nil {
+			// templates in ConfigMaps are validated when they are rendered
+			_, err := template.New("extra").Funcs(ExtraResourceFuncMap()).Parse(*res.RawData)
+			if err != nil {
+				errs = append(errs, field.Invalid(p.Child("extraResources").Index(i).Child("rawData"), *res.RawData, err.Error()))
+			}
+		}
+	}
+	if r.Spec.PollInterval != nil && r.Spec.PollInterval.Duration < MinPollInterval {
+		errs = append(errs, field.Invalid(p.Child("pollInterval"), r.Spec.PollInterval.Duration.String(), "should be at least "+MinPollInterval.String()))
+	}
+	if r.Spec.Ingress != nil && r.Spec.HTTPRoute != nil {
+		errs = append(errs, field.Forbidden(p, "only one of ingress and httpRoute may be specified"))
+	}
+	if r.Spec.Ingress != nil || r.Spec.HTTPRoute != nil {
+		errs = append(errs, validatePublicURL(r.Spec.PublicURL, p.Child("publicURL"))...)
+	}
+	if r.Spec.Previews != nil {
+		if len(r.Spec.Previews.Host) == 0 {
+			errs = append(errs, field.Required(p.Child("previews", "host"), ""))
+		} else if _, err := template.New("host").Parse(r.Spec.Previews.Host); err != nil {
+			errs = append(errs, field.Invalid(p.Child("previews", "host"), r.Spec.Previews.Host, err.Error()))
+		}
+		if r.Spec.Previews.MaxAge != nil && r.Spec.Previews.MaxAge.Duration <= 0 {
+			errs = append(errs, field.Invalid(p.Child("previews", "maxAge"), r.Spec.Previews.MaxAge.Duration.String(), "should be positive"))
+		}
+	}
+	if r.Spec.BuildCache != nil && r.Spec.Artifacts == nil {
+		// every replica of nginx would build the site with the shared cache at the same time
+		errs = append(errs, field.Forbidden(p.Child("buildCache"), "buildCache is available only with artifacts"))
+	}
+	if r.Spec.Build != nil && r.Spec.Build.Timeout != nil && r.Spec.Build.Timeout.Duration < time.Second {
+		errs = append(errs, field.Invalid(p.Child("build", "timeout"), r.Spec.Build.Timeout.Duration.String(), "should be at least 1s"))
+	}
+	if r.Spec.GitAuth != nil {
+		errs = append(errs, validateGitAuth(r.Spec.GitAuth, p.Child("gitAuth"))...)
+	}
+	for i, v := range r.Spec.VolumeTemplates {
+		if !slices.Contains(VolumeTemplateNames, v.Name) {
+			errs = append(errs, field.NotSupported(p.Child("volumeTemplates").Index(i).Child("name"), v.Name, VolumeTemplateNames))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WebSite").GroupKind(), r.Name, errs)
+}
+
+func validateDataSource(ds *DataSource, p *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if ds.ConfigMap != nil && ds.RawData != nil {
+		errs = append(errs, field.Forbidden(p, "only one of configMap and rawData may be specified"))
+	}
+	if ds.ConfigMap == nil && ds.RawData == nil {
+		errs = append(errs, field.Required(p, "either configMap or rawData must be specified"))
+	}
+	return errs
+}
+
+func validateGitAuth(auth *GitAuth, p *field.Path) field.ErrorList {
+	if auth.SecretName != nil && auth.GitHubApp != nil {
+		return field.ErrorList{field.Forbidden(p, "only one of secretName and githubApp may be specified")}
+	}
+	if auth.SecretName == nil && auth.GitHubApp == nil {
+		return field.ErrorList{field.Required(p, "either secretName or githubApp must be specified")}
+	}
+	if auth.GitHubApp == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	app := auth.GitHubApp
+	if len(app.PrivateKeySecretName) == 0 {
+		errs = append(errs, field.Required(p.Child("githubApp", "privateKeySecretName"), ""))
+	}
+	if len(app.APIURL) != 0 {
+		u, err := url.Parse(app.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			errs = append(errs, field.Invalid(p.Child("githubApp", "apiURL"), app.APIURL, "should be an http or https URL"))
+		}
+	}
+	return errs
+}
+
+func validateRepoURL(repoURL string, p *field.Path) field.ErrorList {
+	if len(repoURL) == 0 {
+		return field.ErrorList{field.Required(p, "")}
+	}
+	if scpLikeURL.MatchString(repoURL) {
+		return nil
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return field.ErrorList{field.Invalid(p, repoURL, err.Error())}
+	}
+	if !slices.Contains(repoURLSchemes, u.Scheme) {
+		return field.ErrorList{field.Invalid(p, repoURL, "unsupported URL scheme")}
+	}
+	if u.Scheme != "file" && len(u.Host) == 0 {
+		return field.ErrorList{field.Invalid(p, repoURL, "host should not be empty")}
+	}
+	return nil
+}
+
+func validatePublicURL(publicURL string, p *field.Path) field.ErrorList {
+	if len(publicURL) == 0 {
+		return field.ErrorList{field.Required(p, "publicURL is required to route the website")}
+	}
+	u, err := url.Parse(publicURL)
+	if err != nil {
+		return field.ErrorList{field.Invalid(p, publicURL, err.Error())}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return field.ErrorList{field.Invalid(p, publicURL, "scheme should be http or https")}
+	}
+	if len(u.Hostname()) == 0 {
+		return field.ErrorList{field.Invalid(p, publicURL, "host should not be empty")}
+	}
+	return nil
+}
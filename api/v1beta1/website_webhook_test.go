@@ -0,0 +1,157 @@
+package v1beta1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func makeWebSite() *WebSite {
+	return &WebSite{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mysite",
+			Namespace: "test",
+		},
+		Spec: WebSiteSpec{
+			BuildImage: "ghcr.io/zoetrope/node:22.16.0",
+			BuildScript: DataSource{
+				RawData: ptr.To("#!/bin/bash\n"),
+			},
+			RepoURL: "https://github.com/neco-test/honkit-sample.git",
+		},
+	}
+}
+
+var _ = Describe("WebSite webhook", func() {
+	AfterEach(func() {
+		err := k8sClient.DeleteAllOf(ctx, &WebSite{}, client.InNamespace("test"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should fill default values", func() {
+		site := makeWebSite()
+		site.Spec.Artifacts = &ArtifactsStorage{}
+		site.Spec.BuildCache = &BuildCacheStorage{}
+		err := k8sClient.Create(ctx, site)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(site.Spec.Branch).Should(Equal("main"))
+		Expect(site.Spec.Replicas).Should(Equal(int32(1)))
+		Expect(site.Spec.RevisionHistoryLimit).Should(Equal(ptr.To[int32](10)))
+		Expect(site.Spec.Artifacts.AccessModes).Should(ConsistOf(corev1.ReadWriteMany))
+		Expect(site.Spec.Artifacts.Size.String()).Should(Equal("1Gi"))
+		Expect(site.Spec.Artifacts.Retention).Should(Equal(int32(2)))
+		Expect(site.Spec.BuildCache.AccessModes).Should(ConsistOf(corev1.ReadWriteMany))
+		Expect(site.Spec.BuildCache.Size.String()).Should(Equal("5Gi"))
+	})
+
+	It("should accept scp-like repository URLs", func() {
+		site := makeWebSite()
+		site.Spec.RepoURL = "git@github.com:neco-test/mkdocs-sample.git"
+		err := k8sClient.Create(ctx, site)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should accept WebSites built by BuildTemplate without the build image and the build script", func() {
+		site := makeWebSite()
+		site.Spec.BuildImage = ""
+		site.Spec.BuildScript = DataSource{}
+		site.Spec.BuildTemplate = &BuildTemplateRef{Name: "honkit", Parameters: map[string]string{"OUTPUT_DIR": "_book"}}
+		err := k8sClient.Create(ctx, site)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should accept extra resources using the template functions", func() {
+		site := makeWebSite()
+		site.Spec.ExtraResources = []DataSource{{RawData: ptr.To("metadata:\n  labels:\n    {{- toYaml .Labels | nindent 4 }}\n  name: {{ .ResourceName | default \"site\" | quote }}\n")}}
+		err := k8sClient.Create(ctx, site)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	DescribeTable("should reject invalid WebSites",
+		func(mutate func(*WebSite)) {
+			site := makeWebSite()
+			mutate(site)
+			err := k8sClient.Create(ctx, site)
+			Expect(apierrors.IsInvalid(err)).Should(BeTrue(), "unexpected error: %v", err)
+		},
+		Entry("both configMap and rawData", func(site *WebSite) {
+			site.Spec.BuildScript.ConfigMap = &ConfigMapSource{Name: "build-scripts", Key: "build.sh"}
+		}),
+		Entry("empty build script", func(site *WebSite) {
+			site.Spec.BuildScript.RawData = ptr.To("")
+		}),
+		Entry("no build script", func(site *WebSite) {
+			site.Spec.BuildScript = DataSource{}
+		}),
+		Entry("no build image", func(site *WebSite) {
+			site.Spec.BuildImage = ""
+		}),
+		Entry("malformed repository URL", func(site *WebSite) {
+			site.Spec.RepoURL = "github.com/neco-test/honkit-sample"
+		}),
+		Entry("unsupported URL scheme", func(site *WebSite) {
+			site.Spec.RepoURL = "ftp://example.com/honkit-sample.git"
+		}),
+		Entry("unparsable extra resource", func(site *WebSite) {
+			site.Spec.ExtraResources = []DataSource{{RawData: ptr.To("name: {{ .ResourceName ")}}
+		}),
+		Entry("unknown volume template", func(site *WebSite) {
+			site.Spec.VolumeTemplates = []corev1.Volume{{Name: "unknown"}}
+		}),
+		Entry("too short poll interval", func(site *WebSite) {
+			site.Spec.PollInterval = &metav1.Duration{Duration: time.Second}
+		}),
+		Entry("ingress without publicURL", func(site *WebSite) {
+			site.Spec.Ingress = &IngressSpec{}
+		}),
+		Entry("both ingress and httpRoute", func(site *WebSite) {
+			site.Spec.PublicURL = "https://docs.example.com/"
+			site.Spec.Ingress = &IngressSpec{}
+			site.Spec.HTTPRoute = &HTTPRouteSpec{ParentRefs: []ParentReference{{Name: "gateway"}}}
+		}),
+		Entry("unparsable preview host", func(site *WebSite) {
+			site.Spec.Previews = &PreviewsSpec{Host: "pr-{{ .Number .example.com"}
+		}),
+		Entry("non-positive preview maxAge", func(site *WebSite) {
+			site.Spec.Previews = &PreviewsSpec{Host: "pr-{{ .Number }}.example.com", MaxAge: &metav1.Duration{}}
+		}),
+		Entry("empty gitAuth", func(site *WebSite) {
+			site.Spec.GitAuth = &GitAuth{}
+		}),
+		Entry("both secretName and githubApp", func(site *WebSite) {
+			site.Spec.GitAuth = &GitAuth{
+				SecretName: ptr.To("git-token"),
+				GitHubApp:  &GitHubAppAuth{AppID: 1, InstallationID: 2, PrivateKeySecretName: "github-app"},
+			}
+		}),
+		Entry("githubApp without private key", func(site *WebSite) {
+			site.Spec.GitAuth = &GitAuth{GitHubApp: &GitHubAppAuth{AppID: 1, InstallationID: 2}}
+		}),
+		Entry("too short build timeout", func(site *WebSite) {
+			site.Spec.Build = &BuildSpec{Timeout: &metav1.Duration{Duration: time.Millisecond}}
+		}),
+		Entry("buildCache without artifacts", func(site *WebSite) {
+			site.Spec.BuildCache = &BuildCacheStorage{}
+		}),
+		Entry("reserved template parameter", func(site *WebSite) {
+			site.Spec.BuildTemplate = &BuildTemplateRef{Name: "honkit", Parameters: map[string]string{"OUTPUT": "/tmp"}}
+		}),
+	)
+
+	It("should validate updates", func() {
+		site := makeWebSite()
+		err := k8sClient.Create(ctx, site)
+		Expect(err).NotTo(HaveOccurred())
+
+		site.Spec.NginxConf = &DataSource{}
+		err = k8sClient.Update(ctx, site)
+		Expect(apierrors.IsInvalid(err)).Should(BeTrue(), "unexpected error: %v", err)
+	})
+})
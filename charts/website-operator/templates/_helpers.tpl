@@ -51,6 +51,15 @@ app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
 app.kubernetes.io/managed-by: {{ .Release.Service }}
 {{- end }}
 
+{{- define "website-operator-repo-checker.labels" -}}
+helm.sh/chart: {{ include "website-operator.chart" . }}
+{{ include "website-operator-repo-checker.selectorLabels" . }}
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
 {{/*
 Selector labels
 */}}
@@ -64,6 +73,11 @@ app.kubernetes.io/name: {{ include "website-operator.name" . }}-ui
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{- define "website-operator-repo-checker.selectorLabels" -}}
+app.kubernetes.io/name: {{ include "website-operator.name" . }}-repo-checker
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
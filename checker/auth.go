@@ -0,0 +1,128 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cybozu-go/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// Environment variables to pass the credentials for HTTPS to repo-checker.
+// They are passed by environment variables not to expose them in the command line.
+const (
+	UsernameEnv = "GIT_USERNAME"
+	PasswordEnv = "GIT_PASSWORD"
+)
+
+// knownHostsFile is the name of the file that contains the host keys of the Git server.
+// It is looked up in the same directory as the SSH key.
+const knownHostsFile = "known_hosts"
+
+// Auth is the credentials to access the repository.
+type Auth struct {
+	// SSHKeyFile is the path of the private key for SSH.
+	// The host key of the Git server is verified by known_hosts placed in the same directory.
+	SSHKeyFile string
+	// InsecureIgnoreHostKey skips the verification of the host key if known_hosts is not placed.
+	InsecureIgnoreHostKey bool
+	// Username and Password are used for HTTPS. Password may be an access token.
+	Username string
+	Password string
+	// CredentialsDir is the directory that contains the files named username and password for HTTPS.
+	// The files are read every time the repository is accessed, so that the rotated credentials are used.
+	CredentialsDir string
+}
+
+// AuthFromEnv returns Auth that has the credentials for HTTPS in the environment variables.
+func AuthFromEnv(sshKeyFile, credentialsDir string, insecureIgnoreHostKey bool) Auth {
+	return Auth{
+		SSHKeyFile:            sshKeyFile,
+		InsecureIgnoreHostKey: insecureIgnoreHostKey,
+		Username:              os.Getenv(UsernameEnv),
+		Password:              os.Getenv(PasswordEnv),
+		CredentialsDir:        credentialsDir,
+	}
+}
+
+// method returns the auth method for the scheme of repoURL, or nil to access the repository anonymously.
+func (a Auth) method(repoURL string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "ssh":
+		if len(a.SSHKeyFile) == 0 {
+			return nil, errors.New("SSH key is required for " + repoURL)
+		}
+		user := ep.User
+		if len(user) == 0 {
+			user = "git"
+		}
+		keys, err := gitssh.NewPublicKeysFromFile(user, a.SSHKeyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		knownHosts := filepath.Join(filepath.Dir(a.SSHKeyFile), knownHostsFile)
+		_, err = os.Stat(knownHosts)
+		switch {
+		case err == nil:
+			keys.HostKeyCallback, err = gitssh.NewKnownHostsCallback(knownHosts)
+			if err != nil {
+				return nil, err
+			}
+		case errors.Is(err, fs.ErrNotExist) && a.InsecureIgnoreHostKey:
+			log.Warn("the host key of the Git server is not verified", map[string]interface{}{
+				"repository": repoURL,
+			})
+			keys.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("%s is required to verify the host key of %s", knownHosts, ep.Host)
+		default:
+			return nil, err
+		}
+		return keys, nil
+	case "http", "https":
+		if len(a.CredentialsDir) != 0 {
+			a, err = a.readCredentials()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(a.Password) == 0 {
+			return nil, nil
+		}
+		user := a.Username
+		if len(user) == 0 {
+			// GitHub and GitLab accept any user name with an access token
+			user = "git"
+		}
+		return &githttp.BasicAuth{Username: user, Password: a.Password}, nil
+	}
+	return nil, nil
+}
+
+// readCredentials returns Auth that has the username and the password in CredentialsDir.
+func (a Auth) readCredentials() (Auth, error) {
+	password, err := os.ReadFile(filepath.Join(a.CredentialsDir, "password"))
+	if err != nil {
+		return a, err
+	}
+	a.Password = strings.TrimSpace(string(password))
+	username, err := os.ReadFile(filepath.Join(a.CredentialsDir, "username"))
+	if err == nil {
+		a.Username = strings.TrimSpace(string(username))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return a, err
+	}
+	return a, nil
+}
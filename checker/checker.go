@@ -3,43 +3,106 @@ package checker
 import (
 	"context"
 	"errors"
-	"path/filepath"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/cybozu-go/well"
+	"github.com/Masterminds/semver/v3"
+	"github.com/cybozu-go/log"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
+// TagHeader is the response header of repo-checker that contains the tag name of the latest revision.
+const TagHeader = "X-Repo-Checker-Tag"
+
+// PullRequest represents the head of a pull request (or a merge request of GitLab).
+// GitHub and Gitea keep the refs of the closed pull requests, so they are listed too.
+type PullRequest struct {
+	Number   int    `json:"number"`
+	Ref      string `json:"ref"`
+	Revision string `json:"revision"`
+	// Timestamp is the committer timestamp of the head, which tells when the pull request was updated for the last time.
+	// It is empty if the metadata of the commits are not fetched.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// Revision is the latest revision of the repository returned by the API of repo-checker.
+// The metadata of the commit are empty if they have not been fetched yet.
+type Revision struct {
+	Hash string `json:"hash"`
+	// Ref is the full name of the ref that points to the commit such as refs/heads/main.
+	Ref string `json:"ref"`
+	// Tag is the name of the tag. It is empty unless a tag is checked.
+	Tag       string     `json:"tag,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// CheckedAt is the time when the repository was checked successfully for the last time.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// pullRequestRefPrefixes are the prefixes of the refs of pull requests on GitHub/Gitea and GitLab.
+var pullRequestRefPrefixes = []string{"refs/pull/", "refs/merge-requests/"}
+
 type RepoChecker struct {
 	latestRevision string
+	latestTag      string
+	latestRef      string
+	commit         *commitInfo
+	// commitFailed is the hash whose metadata failed to be fetched, not to fetch it again at every check
+	commitFailed string
+	checkedAt    time.Time
+	pullRequests []PullRequest
+	// pullTimestamps caches the timestamps of the heads of the pull requests. It is nil for the heads that failed to be fetched.
+	pullTimestamps map[string]*time.Time
 	mu             sync.Mutex
 
 	repoURL    string
 	repoBranch string
-	repoName   string
-	workDir    string
+	repoTag    string
+	auth       Auth
 	interval   time.Duration
+	jitter     float64
+	// commitMetadata enables fetching the metadata of the latest commit
+	commitMetadata bool
 }
 
-func NewRepoChecker(repoURL, repoBranch, workDir string, interval time.Duration) *RepoChecker {
-	items := strings.Split(repoURL, "/")
-	last := items[len(items)-1]
-	repoName := strings.TrimSuffix(last, ".git")
-
+// NewRepoChecker creates RepoChecker.
+// If repoTag is not empty, the tag that matches it is checked instead of the head of repoBranch.
+// The repository is checked every interval plus a random delay up to jitter times interval.
+func NewRepoChecker(repoURL, repoBranch, repoTag string, interval time.Duration, jitter float64) *RepoChecker {
 	return &RepoChecker{
-		repoURL:    repoURL,
-		repoBranch: repoBranch,
-		repoName:   repoName,
-		workDir:    workDir,
-		interval:   interval,
+		repoURL:        repoURL,
+		repoBranch:     repoBranch,
+		repoTag:        repoTag,
+		interval:       interval,
+		jitter:         jitter,
+		commitMetadata: true,
 	}
 }
 
-func (c *RepoChecker) Clone(ctx context.Context) error {
-	cmd := well.CommandContext(ctx, "git", "clone", "-b", c.repoBranch, c.repoURL)
-	cmd.Dir = c.workDir
-	return cmd.Run()
+// SetAuth sets the credentials to access the repository.
+func (c *RepoChecker) SetAuth(auth Auth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.auth = auth
+}
+
+// SetCommitMetadata enables or disables fetching the author, the message and the timestamp of the latest commit.
+// It is enabled by default.
+func (c *RepoChecker) SetCommitMetadata(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commitMetadata = enabled
 }
 
 func (c *RepoChecker) UpdateLatestRevision(ctx context.Context) error {
@@ -48,14 +111,13 @@ func (c *RepoChecker) UpdateLatestRevision(ctx context.Context) error {
 		return err
 	}
 
-	ticker := time.NewTicker(c.interval)
-	defer ticker.Stop()
-
 	for {
+		timer := time.NewTimer(c.nextInterval())
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return ctx.Err()
-		case <-ticker.C:
+		case <-timer.C:
 			err := c.fetchRemoteRevision(ctx)
 			if err != nil {
 				return err
@@ -64,31 +126,321 @@ func (c *RepoChecker) UpdateLatestRevision(ctx context.Context) error {
 	}
 }
 
+// nextInterval returns the interval with jitter, so that many repo-checkers do not access the Git server at the same time.
+func (c *RepoChecker) nextInterval() time.Duration {
+	c.mu.Lock()
+	interval := c.interval
+	c.mu.Unlock()
+	if c.jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Float64()*c.jitter*float64(interval))
+}
+
+// SetInterval changes the interval to check the repository. It takes effect from the next check.
+func (c *RepoChecker) SetInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interval = interval
+}
+
 func (c *RepoChecker) LatestRevision() string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.latestRevision
 }
 
+// LatestTag returns the tag name of the latest revision. It is empty unless a tag is checked.
+func (c *RepoChecker) LatestTag() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latestTag
+}
+
+// Revision returns the latest revision with the metadata of the commit.
+// It returns false if the repository has not been checked yet.
+func (c *RepoChecker) Revision() (Revision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.latestRevision) == 0 {
+		return Revision{}, false
+	}
+	rev := Revision{
+		Hash:      c.latestRevision,
+		Ref:       c.latestRef,
+		Tag:       c.latestTag,
+		CheckedAt: c.checkedAt,
+	}
+	if c.commit != nil && c.commit.hash == c.latestRevision {
+		rev.Author = c.commit.author
+		rev.Message = c.commit.message
+		rev.Timestamp = &c.commit.timestamp
+	}
+	return rev, true
+}
+
+// SetLatestRevision updates the latest revision, and returns true if it has been changed.
+func (c *RepoChecker) SetLatestRevision(rev string) bool {
+	return c.setLatest(rev, "refs/heads/"+c.repoBranch, "")
+}
+
+func (c *RepoChecker) setLatest(rev, ref, tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latestRevision == rev && c.latestTag == tag {
+		return false
+	}
+	c.latestRevision = rev
+	c.latestRef = ref
+	c.latestTag = tag
+	return true
+}
+
+// PullRequests returns the pull requests, the newest first.
+func (c *RepoChecker) PullRequests() []PullRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.pullRequests)
+}
+
+// Branch returns the name of the branch to be checked.
+func (c *RepoChecker) Branch() string {
+	return c.repoBranch
+}
+
+// Tag returns the tag or the version constraint to be checked.
+func (c *RepoChecker) Tag() string {
+	return c.repoTag
+}
+
+// Refresh fetches the latest revision from the remote repository, and returns true if it has been changed.
+func (c *RepoChecker) Refresh(ctx context.Context) (bool, error) {
+	before := c.LatestRevision()
+	err := c.fetchRemoteRevision(ctx)
+	if err != nil {
+		return false, err
+	}
+	return before != c.LatestRevision(), nil
+}
+
+// lsRemote lists the refs of the remote repository without cloning it.
+// The peeled refs of annotated tags are listed with the suffix "^{}" like git ls-remote.
+func (c *RepoChecker) lsRemote(ctx context.Context) ([]*plumbing.Reference, error) {
+	method, err := c.authMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{c.repoURL},
+	})
+	return remote.ListContext(ctx, &git.ListOptions{
+		Auth:          method,
+		PeelingOption: git.AppendPeeled,
+	})
+}
+
+func (c *RepoChecker) authMethod() (transport.AuthMethod, error) {
+	c.mu.Lock()
+	auth := c.auth
+	c.mu.Unlock()
+	return auth.method(c.repoURL)
+}
+
+// updateCommit fetches the metadata of the latest commit unless it has been fetched.
+// The metadata are optional, so the failure is only logged and not retried until the revision is changed.
+func (c *RepoChecker) updateCommit(ctx context.Context) {
+	c.mu.Lock()
+	hash, ref := c.latestRevision, c.latestRef
+	skip := !c.commitMetadata || len(hash) == 0 || c.commitFailed == hash || (c.commit != nil && c.commit.hash == hash)
+	c.mu.Unlock()
+	if skip {
+		return
+	}
+
+	commits, err := c.fetchCommits(ctx, []plumbing.Hash{plumbing.NewHash(hash)})
+	if err != nil {
+		fields := map[string]interface{}{
+			"repo":      c.repoURL,
+			"ref":       ref,
+			"revision":  hash,
+			log.FnError: err,
+		}
+		if errors.Is(err, errPartialFetchUnsupported) {
+			log.Warn("skip fetching the metadata of the commit", fields)
+		} else {
+			log.Error("failed to fetch the commit", fields)
+		}
+		c.mu.Lock()
+		c.commitFailed = hash
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Lock()
+	c.commit = commits[plumbing.NewHash(hash)]
+	c.mu.Unlock()
+}
+
+// updatePullRequestTimestamps sets the timestamps of the heads of pulls.
+// Only the heads that have not been fetched are fetched at once, and the failure is only logged and not retried until the heads are changed.
+func (c *RepoChecker) updatePullRequestTimestamps(ctx context.Context, pulls []PullRequest) {
+	c.mu.Lock()
+	enabled, cached := c.commitMetadata, c.pullTimestamps
+	c.mu.Unlock()
+	if !enabled {
+		return
+	}
+
+	timestamps := make(map[string]*time.Time, len(pulls))
+	var missing []plumbing.Hash
+	for _, pr := range pulls {
+		ts, ok := cached[pr.Revision]
+		if !ok {
+			missing = append(missing, plumbing.NewHash(pr.Revision))
+		}
+		timestamps[pr.Revision] = ts
+	}
+	if len(missing) != 0 {
+		commits, err := c.fetchCommits(ctx, missing)
+		if err != nil {
+			fields := map[string]interface{}{
+				"repo":      c.repoURL,
+				log.FnError: err,
+			}
+			if errors.Is(err, errPartialFetchUnsupported) {
+				log.Warn("skip fetching the heads of the pull requests", fields)
+			} else {
+				log.Error("failed to fetch the heads of the pull requests", fields)
+			}
+		}
+		for hash, commit := range commits {
+			timestamps[hash.String()] = &commit.timestamp
+		}
+	}
+
+	for i := range pulls {
+		pulls[i].Timestamp = timestamps[pulls[i].Revision]
+	}
+	c.mu.Lock()
+	c.pullTimestamps = timestamps
+	c.mu.Unlock()
+}
+
 func (c *RepoChecker) fetchRemoteRevision(ctx context.Context) error {
-	cmd := well.CommandContext(ctx, "git", "ls-remote", "origin")
-	cmd.Dir = filepath.Join(c.workDir, c.repoName)
-	out, err := cmd.Output()
+	start := time.Now()
+	refs, err := c.lsRemote(ctx)
+	lsRemoteDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		lsRemoteFailures.Inc()
 		return err
 	}
-	for _, o := range strings.Split(string(out), "\n") {
-		fields := strings.Fields(o)
-		if len(fields) != 2 {
+
+	heads := make(map[string]string)
+	tags := make(map[string]string)
+	var pulls []PullRequest
+	for _, r := range refs {
+		// symbolic refs such as HEAD have no hash
+		if r.Type() != plumbing.HashReference {
 			continue
 		}
-		ref := strings.TrimSpace(fields[1])
-		if ref == "refs/heads/"+c.repoBranch {
-			c.mu.Lock()
-			c.latestRevision = strings.TrimSpace(fields[0])
-			c.mu.Unlock()
-			return nil
+		hash := r.Hash().String()
+		ref := r.Name().String()
+		if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			heads[name] = hash
+			continue
+		}
+		if pr, ok := parsePullRequestRef(ref); ok {
+			pr.Revision = hash
+			pulls = append(pulls, pr)
+			continue
 		}
+		if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+			// annotated tags are followed by the peeled ref that points to the commit
+			if peeled, ok := strings.CutSuffix(name, "^{}"); ok {
+				tags[peeled] = hash
+			} else if _, ok := tags[name]; !ok {
+				tags[name] = hash
+			}
+		}
+	}
+	sort.Slice(pulls, func(i, j int) bool {
+		return pulls[i].Number > pulls[j].Number
+	})
+	c.updatePullRequestTimestamps(ctx, pulls)
+	c.mu.Lock()
+	c.pullRequests = pulls
+	c.mu.Unlock()
+
+	if len(c.repoTag) != 0 {
+		tag, err := resolveTag(tags, c.repoTag)
+		if err != nil {
+			return err
+		}
+		c.setLatest(tags[tag], "refs/tags/"+tag, tag)
+	} else {
+		hash, ok := heads[c.repoBranch]
+		if !ok {
+			return errors.New("cannot found hash")
+		}
+		c.setLatest(hash, "refs/heads/"+c.repoBranch, "")
+	}
+
+	c.mu.Lock()
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+	c.updateCommit(ctx)
+	return nil
+}
+
+// parsePullRequestRef parses refs such as refs/pull/1/head and refs/merge-requests/1/head.
+func parsePullRequestRef(ref string) (PullRequest, bool) {
+	for _, prefix := range pullRequestRefPrefixes {
+		rest, ok := strings.CutPrefix(ref, prefix)
+		if !ok {
+			continue
+		}
+		num, ok := strings.CutSuffix(rest, "/head")
+		if !ok {
+			return PullRequest{}, false
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return PullRequest{}, false
+		}
+		return PullRequest{Number: n, Ref: ref}, true
+	}
+	return PullRequest{}, false
+}
+
+// resolveTag returns the tag that exactly matches the selector.
+// If there is no such tag, the selector is treated as a semver constraint and the highest matching tag is returned.
+func resolveTag(tags map[string]string, selector string) (string, error) {
+	if _, ok := tags[selector]; ok {
+		return selector, nil
+	}
+
+	constraint, err := semver.NewConstraint(selector)
+	if err != nil {
+		return "", fmt.Errorf("tag %s is not found", selector)
+	}
+	var latest *semver.Version
+	latestTag := ""
+	for tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if !constraint.Check(v) {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+			latestTag = tag
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no tag matches %s", selector)
 	}
-	return errors.New("cannot found hash")
+	return latestTag, nil
 }
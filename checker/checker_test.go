@@ -2,31 +2,59 @@ package checker
 
 import (
 	"context"
-	"io/ioutil"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func TestPublicRepository(t *testing.T) {
-	workDir, err := ioutil.TempDir("/tmp", "website-operator-checker-test")
-	if err != nil {
-		t.Fatal(err)
+	rc := NewRepoChecker("https://github.com/neco-test/honkit-sample.git", "main", "", 5*time.Second, 0)
+	ctx := context.Background()
+
+	rev := rc.LatestRevision()
+	if rev != "" {
+		t.Fatal("something wrong")
 	}
-	defer os.RemoveAll(workDir)
 
-	rc := NewRepoChecker("https://github.com/neco-test/honkit-sample.git", "main", workDir, 5*time.Second)
-	ctx := context.Background()
-	err = rc.Clone(ctx)
+	err := rc.fetchRemoteRevision(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = os.Stat(filepath.Join(workDir, rc.repoName, "README.md"))
+
+	rev = rc.LatestRevision()
+	if rev == "" {
+		t.Fatal("failed to get latest revision")
+	}
+}
+
+func TestPrivateRepository(t *testing.T) {
+	wd, err := os.Getwd()
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	rc := NewRepoChecker("git@github.com:neco-test/mkdocs-sample.git", "main", "", 5*time.Second, 0)
+	rc.SetAuth(Auth{SSHKeyFile: filepath.Join(wd, "..", "e2e", "manifests", "website", ".ssh", "id_rsa")})
+	ctx := context.Background()
+
 	rev := rc.LatestRevision()
 	if rev != "" {
 		t.Fatal("something wrong")
@@ -43,45 +71,318 @@ func TestPublicRepository(t *testing.T) {
 	}
 }
 
-func TestPrivateRepository(t *testing.T) {
-	wd, err := os.Getwd()
+// initLocalRepository creates a repository that has the tag v1.0.0 and the pull request #3 on the first commit, and main on the second commit.
+func initLocalRepository(t *testing.T) (string, *object.Signature, plumbing.Hash, plumbing.Hash) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = os.Setenv("GIT_SSH_COMMAND", "ssh -F "+filepath.Join(wd, "..", "e2e", "manifests", "website", ".ssh", "config")+" -i "+filepath.Join(wd, "..", "e2e", "manifests", "website", ".ssh", "id_rsa"))
+	wt, err := repo.Worktree()
 	if err != nil {
 		t.Fatal(err)
 	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	commit := func(msg string) plumbing.Hash {
+		h, err := wt.Commit(msg, &git.CommitOptions{AllowEmptyCommits: true, Author: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
 
-	workDir, err := ioutil.TempDir("/tmp", "website-operator-checker-test")
+	first := commit("first")
+	_, err = repo.CreateTag("v1.0.0", first, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(workDir)
+	second := commit("second")
+	err = repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Storer.SetReference(plumbing.NewHashReference("refs/pull/3/head", first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "file://" + filepath.Join(dir, ".git"), sig, first, second
+}
+
+func TestLocalRepository(t *testing.T) {
+	// serve the local repository in-process, so that git is not required
+	client.InstallProtocol("file", server.DefaultServer)
+	defer client.InstallProtocol("file", file.DefaultClient)
+
+	repoURL, _, first, second := initLocalRepository(t)
 
-	rc := NewRepoChecker("git@github.com:neco-test/mkdocs-sample.git", "main", workDir, 5*time.Second)
 	ctx := context.Background()
-	err = rc.Clone(ctx)
+	rc := NewRepoChecker(repoURL, "main", "", time.Minute, 0)
+	err := rc.fetchRemoteRevision(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = os.Stat(filepath.Join(workDir, rc.repoName, "README.md"))
+	if rev := rc.LatestRevision(); rev != second.String() {
+		t.Errorf("unexpected revision of the branch: %s", rev)
+	}
+	rev, ok := rc.Revision()
+	if !ok {
+		t.Fatal("revision is not found")
+	}
+	// the in-process server does not support the partial clone, so the metadata are skipped
+	if rev.Ref != "refs/heads/main" || rev.Author != "" || rev.Timestamp != nil || rev.CheckedAt.IsZero() {
+		t.Errorf("unexpected revision: %+v", rev)
+	}
+	if rc.commitFailed != second.String() {
+		t.Errorf("the commit should not be fetched again: %q", rc.commitFailed)
+	}
+	pulls := rc.PullRequests()
+	if len(pulls) != 1 || pulls[0].Number != 3 || pulls[0].Revision != first.String() || pulls[0].Timestamp != nil {
+		t.Errorf("unexpected pull requests: %v", pulls)
+	}
+
+	rc = NewRepoChecker(repoURL, "main", "~1", time.Minute, 0)
+	err = rc.fetchRemoteRevision(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if rev := rc.LatestRevision(); rev != first.String() {
+		t.Errorf("unexpected revision of the tag: %s", rev)
+	}
+	if tag := rc.LatestTag(); tag != "v1.0.0" {
+		t.Errorf("unexpected tag: %s", tag)
+	}
+	rev, _ = rc.Revision()
+	if rev.Ref != "refs/tags/v1.0.0" {
+		t.Errorf("unexpected revision of the tag: %+v", rev)
+	}
+}
 
-	rev := rc.LatestRevision()
-	if rev != "" {
-		t.Fatal("something wrong")
+func TestCommitMetadata(t *testing.T) {
+	// the partial clone is served by git-upload-pack
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack is not found")
+	}
+	repoURL, sig, _, second := initLocalRepository(t)
+	dir := strings.TrimPrefix(repoURL, "file://")
+	err := exec.Command("git", "--git-dir", dir, "config", "uploadpack.allowFilter", "true").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	rc := NewRepoChecker(repoURL, "main", "", time.Minute, 0)
+	err = rc.fetchRemoteRevision(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, _ := rc.Revision()
+	if rev.Hash != second.String() || rev.Author != "test" || rev.Message != "second" {
+		t.Errorf("unexpected revision: %+v", rev)
+	}
+	if rev.Timestamp == nil || !rev.Timestamp.Equal(sig.When.Truncate(time.Second)) {
+		t.Errorf("unexpected timestamp: %v", rev.Timestamp)
 	}
 
+	pulls := rc.PullRequests()
+	if len(pulls) != 1 || pulls[0].Timestamp == nil || !pulls[0].Timestamp.Equal(sig.When.Truncate(time.Second)) {
+		t.Errorf("unexpected pull requests: %v", pulls)
+	}
+
+	commits, err := rc.fetchCommits(ctx, []plumbing.Hash{second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[second].message != "second" {
+		t.Errorf("unexpected commits: %v", commits)
+	}
+
+	rc = NewRepoChecker(repoURL, "main", "", time.Minute, 0)
+	rc.SetCommitMetadata(false)
 	err = rc.fetchRemoteRevision(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
+	rev, _ = rc.Revision()
+	if rev.Hash != second.String() || rev.Author != "" || rev.Timestamp != nil {
+		t.Errorf("the metadata should not be fetched: %+v", rev)
+	}
+}
 
-	rev = rc.LatestRevision()
-	if rev == "" {
-		t.Fatal("failed to get latest revision")
+func TestResolveTag(t *testing.T) {
+	tags := map[string]string{
+		"v1.0.0":     "a",
+		"v1.2.0":     "b",
+		"v2.0.0":     "c",
+		"v2.1.3":     "d",
+		"v3.0.0-rc1": "e",
+		"latest":     "f",
+	}
+
+	testCases := []struct {
+		selector string
+		expected string
+		err      bool
+	}{
+		{selector: "latest", expected: "latest"},
+		{selector: "v1.2.0", expected: "v1.2.0"},
+		{selector: ">=2.0.0 <3", expected: "v2.1.3"},
+		{selector: "~1", expected: "v1.2.0"},
+		{selector: "^1.0.0", expected: "v1.2.0"},
+		{selector: "*", expected: "v2.1.3"},
+		{selector: ">=4", err: true},
+		{selector: "nightly", err: true},
+	}
+
+	for _, tc := range testCases {
+		tag, err := resolveTag(tags, tc.selector)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: error should occur", tc.selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.selector, err)
+			continue
+		}
+		if tag != tc.expected {
+			t.Errorf("%s: expected %s, actual %s", tc.selector, tc.expected, tag)
+		}
+	}
+}
+
+func TestParsePullRequestRef(t *testing.T) {
+	testCases := []struct {
+		ref    string
+		number int
+		ok     bool
+	}{
+		{ref: "refs/pull/12/head", number: 12, ok: true},
+		{ref: "refs/merge-requests/3/head", number: 3, ok: true},
+		{ref: "refs/pull/12/merge", ok: false},
+		{ref: "refs/pull/abc/head", ok: false},
+		{ref: "refs/heads/main", ok: false},
+	}
+
+	for _, tc := range testCases {
+		pr, ok := parsePullRequestRef(tc.ref)
+		if ok != tc.ok {
+			t.Errorf("%s: expected %v, actual %v", tc.ref, tc.ok, ok)
+			continue
+		}
+		if ok && pr.Number != tc.number {
+			t.Errorf("%s: expected %d, actual %d", tc.ref, tc.number, pr.Number)
+		}
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	rc := NewRepoChecker("https://github.com/neco-test/honkit-sample.git", "main", "", time.Minute, 0)
+	if d := rc.nextInterval(); d != time.Minute {
+		t.Errorf("unexpected interval without jitter: %v", d)
+	}
+
+	rc = NewRepoChecker("https://github.com/neco-test/honkit-sample.git", "main", "", time.Minute, 0.5)
+	for i := 0; i < 100; i++ {
+		d := rc.nextInterval()
+		if d < time.Minute || d > 90*time.Second {
+			t.Fatalf("interval out of range: %v", d)
+		}
+	}
+}
+
+func TestAuthMethod(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "password"), []byte("token1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := Auth{CredentialsDir: dir}
+
+	method, err := auth.method("https://github.com/neco-test/honkit-sample.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, ok := method.(*githttp.BasicAuth)
+	if !ok || basic.Username != "git" || basic.Password != "token1" {
+		t.Fatalf("unexpected auth method: %v", method)
+	}
+
+	// rotated credentials should be used
+	err = os.WriteFile(filepath.Join(dir, "username"), []byte("x-access-token"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "password"), []byte("token2"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err = auth.method("https://github.com/neco-test/honkit-sample.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, ok = method.(*githttp.BasicAuth)
+	if !ok || basic.Username != "x-access-token" || basic.Password != "token2" {
+		t.Fatalf("unexpected auth method: %v", method)
+	}
+
+	method, err = Auth{}.method("https://github.com/neco-test/honkit-sample.git")
+	if err != nil || method != nil {
+		t.Fatalf("anonymous access is expected: %v, %v", method, err)
+	}
+
+	_, err = Auth{}.method("git@github.com:neco-test/mkdocs-sample.git")
+	if err == nil {
+		t.Fatal("SSH key should be required")
+	}
+}
+
+func TestAuthMethodHostKey(t *testing.T) {
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_rsa")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoURL := "git@github.com:neco-test/mkdocs-sample.git"
+
+	_, err = Auth{SSHKeyFile: keyFile}.method(repoURL)
+	if err == nil || !strings.Contains(err.Error(), "known_hosts is required") {
+		t.Fatalf("known_hosts should be required: %v", err)
+	}
+
+	method, err := Auth{SSHKeyFile: keyFile, InsecureIgnoreHostKey: true}.method(repoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := method.(*gitssh.PublicKeys); !ok {
+		t.Fatalf("unexpected auth method: %v", method)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownhosts.Line([]string{"github.com"}, signer.PublicKey())+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err = Auth{SSHKeyFile: keyFile}.method(repoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := method.(*gitssh.PublicKeys)
+	err = keys.HostKeyCallback("github.com:22", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}, signer.PublicKey())
+	if err != nil {
+		t.Fatalf("the host key in known_hosts should be accepted: %v", err)
 	}
 }
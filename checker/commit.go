@@ -0,0 +1,121 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// errPartialFetchUnsupported is returned if the Git server cannot send the commits without their trees and ancestors.
+var errPartialFetchUnsupported = errors.New("the Git server does not support shallow fetches with the object filter")
+
+// commitInfo is the metadata of the commit.
+type commitInfo struct {
+	hash      string
+	author    string
+	message   string
+	timestamp time.Time
+}
+
+// fetchCommits fetches only the commit objects of the hashes, and returns their metadata.
+// The trees, the blobs and the ancestors are not fetched by a shallow fetch with the filter "tree:0",
+// so that the memory usage does not depend on the size of the repository.
+// The hashes must be pointed by the refs advertised by the server.
+func (c *RepoChecker) fetchCommits(ctx context.Context, hashes []plumbing.Hash) (_ map[plumbing.Hash]*commitInfo, err error) {
+	method, err := c.authMethod()
+	if err != nil {
+		return nil, err
+	}
+	ep, err := transport.NewEndpoint(c.repoURL)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := client.NewClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := cli.NewUploadPackSession(ep, method)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := sess.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	adv, err := sess.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !adv.Capabilities.Supports(capability.Shallow) || !adv.Capabilities.Supports(capability.Filter) {
+		return nil, errPartialFetchUnsupported
+	}
+
+	req := packp.NewUploadPackRequestFromCapabilities(adv.Capabilities)
+	req.Wants = hashes
+	req.Depth = packp.DepthCommits(1)
+	req.Filter = packp.FilterTreeDepth(0)
+	for _, capa := range []capability.Capability{capability.Shallow, capability.Filter} {
+		if err := req.Capabilities.Set(capa); err != nil {
+			return nil, err
+		}
+	}
+	if adv.Capabilities.Supports(capability.NoProgress) {
+		if err := req.Capabilities.Set(capability.NoProgress); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := sess.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	storage := memory.NewStorage()
+	err = packfile.UpdateObjectStorage(storage, demuxSideband(req.Capabilities, resp))
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make(map[plumbing.Hash]*commitInfo, len(hashes))
+	for _, hash := range hashes {
+		commit, err := object.GetCommit(storage, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", hash, err)
+		}
+		commits[hash] = &commitInfo{
+			hash:      hash.String(),
+			author:    commit.Author.Name,
+			message:   strings.TrimSpace(commit.Message),
+			timestamp: commit.Committer.When,
+		}
+	}
+	return commits, nil
+}
+
+// demuxSideband returns the reader of the packfile multiplexed with the progress messages if the sideband is used.
+func demuxSideband(caps *capability.List, r io.Reader) io.Reader {
+	switch {
+	case caps.Supports(capability.Sideband64k):
+		return sideband.NewDemuxer(sideband.Sideband64k, r)
+	case caps.Supports(capability.Sideband):
+		return sideband.NewDemuxer(sideband.Sideband, r)
+	default:
+		return r
+	}
+}
@@ -0,0 +1,26 @@
+package checker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "repo_checker"
+
+var (
+	lsRemoteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ls_remote_duration_seconds",
+		Help:      "The duration of listing the refs of the remote repository.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	lsRemoteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ls_remote_failures_total",
+		Help:      "The number of failures of listing the refs of the remote repository.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(lsRemoteDuration, lsRemoteFailures)
+}
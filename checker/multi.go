@@ -0,0 +1,243 @@
+package checker
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+// SharedTokenEnv is the name of the environment variable to pass the token that the operator presents to the shared repo-checker.
+const SharedTokenEnv = "SHARED_REPO_CHECKER_TOKEN"
+
+// ErrTooManySites is returned if a new site is registered to MultiRepoChecker that has reached the maximum number of the sites.
+var ErrTooManySites = errors.New("too many sites are registered")
+
+// VerifySharedToken returns true if the request has the bearer token of the operator.
+func VerifySharedToken(req *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && len(token) != 0 && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// Registration is the repository to be checked for a WebSite by the shared repo-checker.
+type Registration struct {
+	RepoURL string
+	Branch  string
+	Tag     string
+	// Interval is the interval to check the repository. The default interval is used if it is zero.
+	Interval time.Duration
+}
+
+type repoKey struct {
+	repoURL string
+	branch  string
+	tag     string
+}
+
+type trackedRepo struct {
+	checker *RepoChecker
+	cancel  context.CancelFunc
+	// intervals is the interval requested by each site
+	intervals map[string]time.Duration
+}
+
+// MultiRepoChecker checks the repositories of many WebSites.
+// The sites that refer to the same repository, branch and tag share one RepoChecker.
+// The registration of a site expires if it has not been accessed for ttl.
+type MultiRepoChecker struct {
+	ctx      context.Context
+	interval time.Duration
+	jitter   float64
+	ttl      time.Duration
+	// commitMetadata enables fetching the metadata of the latest commits
+	commitMetadata bool
+	// maxSites is the maximum number of the registered sites, or zero for unlimited
+	maxSites int
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	repos    map[repoKey]*trackedRepo
+	sites    map[string]repoKey
+	accessed map[string]time.Time
+}
+
+// NewMultiRepoChecker creates MultiRepoChecker.
+// The repositories are checked until ctx is canceled.
+func NewMultiRepoChecker(ctx context.Context, interval time.Duration, jitter float64, ttl time.Duration) *MultiRepoChecker {
+	return &MultiRepoChecker{
+		ctx:            ctx,
+		interval:       interval,
+		jitter:         jitter,
+		ttl:            ttl,
+		commitMetadata: true,
+		repos:          make(map[repoKey]*trackedRepo),
+		sites:          make(map[string]repoKey),
+		accessed:       make(map[string]time.Time),
+	}
+}
+
+// SetCommitMetadata enables or disables fetching the metadata of the latest commits of the repositories registered after that.
+// It is enabled by default.
+func (m *MultiRepoChecker) SetCommitMetadata(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commitMetadata = enabled
+}
+
+// SetMaxSites limits the number of the registered sites. Zero means unlimited.
+func (m *MultiRepoChecker) SetMaxSites(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSites = n
+}
+
+// Register registers or updates the repository of the site, and returns the RepoChecker for it.
+// It fails with ErrTooManySites if the site is new and the number of the sites has reached the limit.
+func (m *MultiRepoChecker) Register(site string, reg Registration) (*RepoChecker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sites[site]; !ok && m.maxSites > 0 && len(m.sites) >= m.maxSites {
+		return nil, ErrTooManySites
+	}
+
+	key := repoKey{repoURL: reg.RepoURL, branch: reg.Branch, tag: reg.Tag}
+	if old, ok := m.sites[site]; ok && old != key {
+		m.unregister(site)
+	}
+	interval := reg.Interval
+	if interval <= 0 {
+		interval = m.interval
+	}
+
+	repo, ok := m.repos[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(m.ctx)
+		rc := NewRepoChecker(reg.RepoURL, reg.Branch, reg.Tag, interval, m.jitter)
+		rc.SetCommitMetadata(m.commitMetadata)
+		repo = &trackedRepo{
+			checker:   rc,
+			cancel:    cancel,
+			intervals: make(map[string]time.Duration),
+		}
+		m.repos[key] = repo
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			run(ctx, rc)
+		}()
+		log.Info("start checking the repository", map[string]interface{}{
+			"repo_url": reg.RepoURL,
+			"branch":   reg.Branch,
+			"tag":      reg.Tag,
+		})
+	}
+	repo.intervals[site] = interval
+	repo.checker.SetInterval(repo.minInterval())
+
+	m.sites[site] = key
+	m.accessed[site] = time.Now()
+	return repo.checker, nil
+}
+
+func (r *trackedRepo) minInterval() time.Duration {
+	var interval time.Duration
+	for _, i := range r.intervals {
+		if interval == 0 || i < interval {
+			interval = i
+		}
+	}
+	return interval
+}
+
+// unregister removes the site, and stops checking the repository that is no longer referred.
+// m.mu must be held.
+func (m *MultiRepoChecker) unregister(site string) {
+	key, ok := m.sites[site]
+	if !ok {
+		return
+	}
+	delete(m.sites, site)
+	delete(m.accessed, site)
+
+	repo := m.repos[key]
+	delete(repo.intervals, site)
+	if len(repo.intervals) != 0 {
+		repo.checker.SetInterval(repo.minInterval())
+		return
+	}
+	repo.cancel()
+	delete(m.repos, key)
+	log.Info("stop checking the repository", map[string]interface{}{
+		"repo_url": key.repoURL,
+		"branch":   key.branch,
+		"tag":      key.tag,
+	})
+}
+
+// Repositories returns the number of the repositories being checked.
+func (m *MultiRepoChecker) Repositories() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.repos)
+}
+
+// Run expires the registrations that have not been accessed for ttl until ctx is canceled.
+// It returns after all the repositories have been stopped.
+func (m *MultiRepoChecker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.wg.Wait()
+			return nil
+		case <-ticker.C:
+			m.expire(time.Now())
+		}
+	}
+}
+
+func (m *MultiRepoChecker) expire(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for site, at := range m.accessed {
+		if now.Sub(at) > m.ttl {
+			m.unregister(site)
+		}
+	}
+}
+
+// run checks the repository until ctx is canceled.
+// Unlike the per-site repo-checker, errors are retried so that a broken repository does not affect the others.
+func run(ctx context.Context, rc *RepoChecker) {
+	for {
+		err := rc.UpdateLatestRevision(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error("failed to check the repository", map[string]interface{}{
+			"repo_url":  rc.repoURL,
+			log.FnError: err,
+		})
+		if !sleep(ctx, rc.nextInterval()) {
+			return
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMultiRepoChecker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMultiRepoChecker(ctx, time.Hour, 0, time.Minute)
+	defer func() {
+		cancel()
+		m.wg.Wait()
+	}()
+	reg := Registration{RepoURL: "file:///nonexistent/site.git", Branch: "main"}
+
+	rc1, err := m.Register("default/site1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc2, err := m.Register("default/site2", Registration{RepoURL: reg.RepoURL, Branch: reg.Branch, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc1 != rc2 {
+		t.Fatal("the same repository should be shared")
+	}
+	if m.Repositories() != 1 {
+		t.Fatalf("unexpected number of repositories: %d", m.Repositories())
+	}
+	if d := rc1.nextInterval(); d != time.Minute {
+		t.Errorf("the shortest interval should be used: %v", d)
+	}
+
+	rc3, err := m.Register("default/site3", Registration{RepoURL: reg.RepoURL, Branch: "release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc3 == rc1 {
+		t.Fatal("a different branch should not be shared")
+	}
+	if m.Repositories() != 2 {
+		t.Fatalf("unexpected number of repositories: %d", m.Repositories())
+	}
+
+	// moving to another branch releases the old repository
+	if _, err := m.Register("default/site3", reg); err != nil {
+		t.Fatal(err)
+	}
+	if m.Repositories() != 1 {
+		t.Fatalf("unexpected number of repositories: %d", m.Repositories())
+	}
+
+	m.mu.Lock()
+	m.accessed["default/site2"] = time.Now().Add(-2 * time.Minute)
+	m.mu.Unlock()
+	m.expire(time.Now())
+	if d := rc1.nextInterval(); d != time.Hour {
+		t.Errorf("the interval of the expired site should be removed: %v", d)
+	}
+
+	m.expire(time.Now().Add(2 * time.Minute))
+	if m.Repositories() != 0 {
+		t.Fatalf("unexpected number of repositories: %d", m.Repositories())
+	}
+}
+
+func TestMultiRepoCheckerMaxSites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMultiRepoChecker(ctx, time.Hour, 0, time.Minute)
+	defer func() {
+		cancel()
+		m.wg.Wait()
+	}()
+	m.SetMaxSites(2)
+	reg := Registration{RepoURL: "file:///nonexistent/site.git", Branch: "main"}
+
+	for _, site := range []string{"default/site1", "default/site2"} {
+		if _, err := m.Register(site, reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Register("default/site3", reg); !errors.Is(err, ErrTooManySites) {
+		t.Fatalf("a new site should be rejected: %v", err)
+	}
+	// the registered sites can be updated
+	if _, err := m.Register("default/site1", Registration{RepoURL: reg.RepoURL, Branch: "release"}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.expire(time.Now().Add(2 * time.Minute))
+	if _, err := m.Register("default/site3", reg); err != nil {
+		t.Fatalf("a site should be registered after the others expire: %v", err)
+	}
+}
+
+func TestVerifySharedToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/repos/default/site1", nil)
+	if VerifySharedToken(req, "token") {
+		t.Error("a request without the token should be rejected")
+	}
+	req.Header.Set("Authorization", "Bearer wrong")
+	if VerifySharedToken(req, "token") {
+		t.Error("a request with a wrong token should be rejected")
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	if !VerifySharedToken(req, "token") {
+		t.Error("a request with the token should be accepted")
+	}
+	req.Header.Set("Authorization", "Bearer ")
+	if VerifySharedToken(req, "") {
+		t.Error("an empty token should not be accepted")
+	}
+}
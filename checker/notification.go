@@ -0,0 +1,57 @@
+package checker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers of the notification from repo-checker to the operator.
+// The signature is the HMAC-SHA256 of the timestamp and the path by the webhook secret.
+const (
+	NotificationTimestampHeader = "X-Repo-Checker-Timestamp"
+	NotificationSignatureHeader = "X-Repo-Checker-Signature"
+)
+
+// notificationMaxSkew is the maximum difference between the timestamp of a notification and the time when it is verified,
+// so that a captured notification cannot be replayed later.
+const notificationMaxSkew = 5 * time.Minute
+
+// SignNotification sets the headers that authenticate the notification request by the webhook secret.
+func SignNotification(req *http.Request, secret []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(NotificationTimestampHeader, ts)
+	req.Header.Set(NotificationSignatureHeader, "sha256="+hex.EncodeToString(notificationMAC(secret, ts, req.URL.Path)))
+}
+
+// VerifyNotification verifies the headers set by SignNotification.
+func VerifyNotification(req *http.Request, secret []byte, now time.Time) error {
+	ts := req.Header.Get(NotificationTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > notificationMaxSkew || d < -notificationMaxSkew {
+		return errors.New("timestamp is out of range")
+	}
+	sig, ok := strings.CutPrefix(req.Header.Get(NotificationSignatureHeader), "sha256=")
+	if !ok {
+		return errInvalidSignature
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, notificationMAC(secret, ts, req.URL.Path)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func notificationMAC(secret []byte, ts, path string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "\n" + path))
+	return mac.Sum(nil)
+}
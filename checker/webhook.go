@@ -0,0 +1,163 @@
+package checker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cybozu-go/log"
+)
+
+// WebhookSecretEnv is the name of the environment variable to pass the webhook secret to repo-checker.
+const WebhookSecretEnv = "WEBHOOK_SECRET"
+
+// maxPayloadSize is the maximum size of webhook payloads. GitHub caps payloads at 25 MB.
+const maxPayloadSize = 25 * 1024 * 1024
+
+const zeroRevision = "0000000000000000000000000000000000000000"
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// pushEvent is the subset of the push event payload that is common to GitHub, GitLab and Gitea.
+type pushEvent struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// WebhookHandler receives push events from GitHub, GitLab or Gitea and updates the latest revision of RepoChecker.
+type WebhookHandler struct {
+	checker *RepoChecker
+	secret  []byte
+	notify  func(ctx context.Context) error
+}
+
+// NewWebhookHandler creates WebhookHandler.
+// notify is called when the latest revision has been changed by a push event. It may be nil.
+func NewWebhookHandler(rc *RepoChecker, secret []byte, notify func(ctx context.Context) error) *WebhookHandler {
+	return &WebhookHandler{
+		checker: rc,
+		secret:  secret,
+		notify:  notify,
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := h.verify(r.Header, body)
+	if err != nil {
+		log.Error("failed to verify webhook request", map[string]interface{}{
+			log.FnError: err,
+		})
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if event != "push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var payload pushEvent
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changed, err := h.update(r.Context(), &payload)
+	if err != nil {
+		log.Error("failed to update the latest revision", map[string]interface{}{
+			log.FnError: err,
+		})
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !changed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Info("latest revision is updated by webhook", map[string]interface{}{
+		"revision": h.checker.LatestRevision(),
+		"tag":      h.checker.LatestTag(),
+	})
+
+	if h.notify != nil {
+		// the operator also polls repo-checker, so a failure of the notification is not fatal
+		err := h.notify(r.Context())
+		if err != nil {
+			log.Error("failed to notify the operator", map[string]interface{}{
+				log.FnError: err,
+			})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// update updates the latest revision by the push event, and returns true if it has been changed.
+func (h *WebhookHandler) update(ctx context.Context, payload *pushEvent) (bool, error) {
+	if len(h.checker.Tag()) != 0 {
+		// a pushed tag may not be the highest one that matches the constraint, so resolve it again
+		if !strings.HasPrefix(payload.Ref, "refs/tags/") {
+			return false, nil
+		}
+		return h.checker.Refresh(ctx)
+	}
+
+	if payload.Ref != "refs/heads/"+h.checker.Branch() || len(payload.After) == 0 || payload.After == zeroRevision {
+		return false, nil
+	}
+	return h.checker.SetLatestRevision(payload.After), nil
+}
+
+// verify checks the signature of the request and returns the normalized event type.
+func (h *WebhookHandler) verify(header http.Header, body []byte) (string, error) {
+	switch {
+	case len(header.Get("X-Gitea-Event")) != 0:
+		if !h.validHMAC(header.Get("X-Gitea-Signature"), body) {
+			return "", errInvalidSignature
+		}
+		return header.Get("X-Gitea-Event"), nil
+	case len(header.Get("X-GitHub-Event")) != 0:
+		sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !h.validHMAC(sig, body) {
+			return "", errInvalidSignature
+		}
+		return header.Get("X-GitHub-Event"), nil
+	case len(header.Get("X-Gitlab-Event")) != 0:
+		// GitLab sends the secret token as is
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), h.secret) != 1 {
+			return "", errInvalidSignature
+		}
+		switch header.Get("X-Gitlab-Event") {
+		case "Push Hook", "Tag Push Hook":
+			return "push", nil
+		}
+		return header.Get("X-Gitlab-Event"), nil
+	}
+	return "", errors.New("unsupported webhook request")
+}
+
+func (h *WebhookHandler) validHMAC(signature string, body []byte) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
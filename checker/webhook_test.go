@@ -0,0 +1,164 @@
+package checker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPayload = `{"ref":"refs/heads/main","after":"0123456789abcdef0123456789abcdef01234567"}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhook(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		header   map[string]string
+		status   int
+		revision string
+		notified bool
+	}{
+		{
+			name: "GitHub",
+			body: testPayload,
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign("secret", testPayload),
+			},
+			status:   http.StatusNoContent,
+			revision: "0123456789abcdef0123456789abcdef01234567",
+			notified: true,
+		},
+		{
+			name: "GitHub with invalid signature",
+			body: testPayload,
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign("invalid", testPayload),
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "GitHub ping",
+			body: `{"zen":"Keep it logically awesome."}`,
+			header: map[string]string{
+				"X-GitHub-Event":      "ping",
+				"X-Hub-Signature-256": "sha256=" + sign("secret", `{"zen":"Keep it logically awesome."}`),
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "GitLab",
+			body: testPayload,
+			header: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": "secret",
+			},
+			status:   http.StatusNoContent,
+			revision: "0123456789abcdef0123456789abcdef01234567",
+			notified: true,
+		},
+		{
+			name: "GitLab with invalid token",
+			body: testPayload,
+			header: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": "invalid",
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Gitea",
+			body: testPayload,
+			header: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-Gitea-Signature": sign("secret", testPayload),
+			},
+			status:   http.StatusNoContent,
+			revision: "0123456789abcdef0123456789abcdef01234567",
+			notified: true,
+		},
+		{
+			name: "other branch",
+			body: `{"ref":"refs/heads/feature","after":"0123456789abcdef0123456789abcdef01234567"}`,
+			header: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-Gitea-Signature": sign("secret", `{"ref":"refs/heads/feature","after":"0123456789abcdef0123456789abcdef01234567"}`),
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "unknown provider",
+			body:   testPayload,
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := NewRepoChecker("https://github.com/neco-test/honkit-sample.git", "main", "", 0, 0)
+			notified := false
+			h := NewWebhookHandler(rc, []byte("secret"), func(ctx context.Context) error {
+				notified = true
+				return nil
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("unexpected status: expected %d, actual %d", tc.status, rec.Code)
+			}
+			if rev := rc.LatestRevision(); rev != tc.revision {
+				t.Errorf("unexpected revision: expected %q, actual %q", tc.revision, rev)
+			}
+			if notified != tc.notified {
+				t.Errorf("unexpected notification: expected %v, actual %v", tc.notified, notified)
+			}
+		})
+	}
+}
+
+func TestNotificationSignature(t *testing.T) {
+	now := time.Now()
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/notify/default/mysite", nil)
+		SignNotification(req, []byte("secret"), now)
+		return req
+	}
+
+	if err := VerifyNotification(newRequest(), []byte("secret"), now.Add(time.Minute)); err != nil {
+		t.Errorf("valid notification is rejected: %v", err)
+	}
+	if err := VerifyNotification(newRequest(), []byte("invalid"), now); err == nil {
+		t.Error("notification signed by another secret is accepted")
+	}
+	if err := VerifyNotification(newRequest(), []byte("secret"), now.Add(10*time.Minute)); err == nil {
+		t.Error("old notification is accepted")
+	}
+
+	req := newRequest()
+	req.URL.Path = "/notify/default/other"
+	if err := VerifyNotification(req, []byte("secret"), now); err == nil {
+		t.Error("notification for another site is accepted")
+	}
+	req = httptest.NewRequest(http.MethodPost, "/notify/default/mysite", nil)
+	if err := VerifyNotification(req, []byte("secret"), now); err == nil {
+		t.Error("unsigned notification is accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -10,11 +11,20 @@ import (
 )
 
 var config struct {
-	listenAddr string
-	repoURL    string
-	repoBranch string
-	workDir    string
-	interval   time.Duration
+	listenAddr            string
+	repoURL               string
+	repoBranch            string
+	repoTag               string
+	sshKeyFile            string
+	insecureIgnoreHostKey bool
+	credentialsDir        string
+	interval              time.Duration
+	jitter                float64
+	notifyURL             string
+	shared                bool
+	ttl                   time.Duration
+	maxSites              int
+	commitMetadata        bool
 }
 
 var rootCmd = &cobra.Command{
@@ -23,8 +33,24 @@ var rootCmd = &cobra.Command{
 	Short:   "repo-checker periodically checks the latest hash of the target GitHub repository",
 	Long:    `repo-checker periodically checks the latest hash of the target GitHub repository.`,
 
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if config.interval <= 0 {
+			return errors.New("--interval should be positive")
+		}
+		if config.ttl <= 0 {
+			return errors.New("--registration-ttl should be positive")
+		}
+		if config.maxSites < 0 {
+			return errors.New("--max-sites should not be negative")
+		}
+		return nil
+	},
+
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
+		if config.shared {
+			return sharedMain(cmd.Context())
+		}
 		return subMain(cmd.Context())
 	},
 }
@@ -43,6 +69,15 @@ func init() {
 	fs.StringVar(&config.listenAddr, "listen-addr", ":9090", "The address the endpoint binds to")
 	fs.StringVar(&config.repoURL, "repo-url", "", "The URL of the repository to be checked")
 	fs.StringVar(&config.repoBranch, "repo-branch", "master", "The branch name of the repository")
-	fs.StringVar(&config.workDir, "work-dir", "/tmp/repos", "The working directory")
+	fs.StringVar(&config.repoTag, "repo-tag", "", "The tag name or the semver constraint of the tags to be checked instead of the branch")
+	fs.StringVar(&config.credentialsDir, "credentials-dir", "", "The directory that contains the username and password files for HTTPS. They are read every time the repository is accessed")
+	fs.StringVar(&config.sshKeyFile, "ssh-key-file", "", "The path of the SSH private key to access the repository. The host key is verified by known_hosts in the same directory. The credentials for HTTPS are passed by GIT_USERNAME and GIT_PASSWORD environment variables")
+	fs.BoolVar(&config.insecureIgnoreHostKey, "insecure-ignore-host-key", false, "Skip the verification of the host key if known_hosts is not placed next to the SSH private key")
 	fs.DurationVar(&config.interval, "interval", 10*time.Minute, "The interval to check the repository")
+	fs.Float64Var(&config.jitter, "jitter", 0.1, "The maximum random delay added to the interval, as a fraction of the interval")
+	fs.StringVar(&config.notifyURL, "notify-url", "", "The URL to notify the operator when the latest revision is updated by webhook")
+	fs.BoolVar(&config.shared, "shared", false, "Check the repositories of all the WebSites registered by the operator instead of --repo-url")
+	fs.BoolVar(&config.commitMetadata, "commit-metadata", true, "Fetch the author, the message and the timestamp of the latest commit. Only the commit object is fetched if the Git server supports the partial clone")
+	fs.IntVar(&config.maxSites, "max-sites", 1000, "The maximum number of the WebSites registered in the shared mode. Zero means unlimited")
+	fs.DurationVar(&config.ttl, "registration-ttl", time.Hour, "The duration to keep checking a repository of a WebSite after the last request in the shared mode")
 }
@@ -2,28 +2,40 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cybozu-go/website-operator/checker"
 	"github.com/cybozu-go/well"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func subMain(ctx context.Context) error {
-	_ = os.RemoveAll(config.workDir)
-	err := os.MkdirAll(config.workDir, 0755)
-	if err != nil {
-		return err
-	}
-	rc := checker.NewRepoChecker(config.repoURL, config.repoBranch, config.workDir, config.interval)
-	err = rc.Clone(ctx)
-	if err != nil {
-		return err
-	}
+	rc := checker.NewRepoChecker(config.repoURL, config.repoBranch, config.repoTag, config.interval, config.jitter)
+	rc.SetAuth(checker.AuthFromEnv(config.sshKeyFile, config.credentialsDir, config.insecureIgnoreHostKey))
+	rc.SetCommitMetadata(config.commitMetadata)
 
 	well.Go(rc.UpdateLatestRevision)
 
 	http.HandleFunc("/", createHandler(rc))
+	http.HandleFunc("/pulls", createPullsHandler(rc))
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if len(rc.LatestRevision()) == 0 {
+			http.Error(w, "repository has not been checked yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	http.Handle("/metrics", promhttp.Handler())
+	// the secret is passed by an environment variable not to expose it in the command line
+	if secret := os.Getenv(checker.WebhookSecretEnv); len(secret) != 0 {
+		http.Handle("/webhook", checker.NewWebhookHandler(rc, []byte(secret), notifier(config.notifyURL, []byte(secret))))
+	}
 	serv := &well.HTTPServer{
 		Server: &http.Server{
 			Addr:    config.listenAddr,
@@ -31,7 +43,7 @@ func subMain(ctx context.Context) error {
 		},
 	}
 
-	err = serv.ListenAndServe()
+	err := serv.ListenAndServe()
 	if err != nil {
 		return err
 	}
@@ -44,14 +56,70 @@ func subMain(ctx context.Context) error {
 	return nil
 }
 
+// notifier returns the function to notify the operator. The notification is signed by the webhook secret.
+func notifier(url string, secret []byte) func(context.Context) error {
+	if len(url) == 0 {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return err
+		}
+		checker.SignNotification(req, secret, time.Now())
+		cli := &well.HTTPClient{Client: &http.Client{Timeout: 5 * time.Second}}
+		resp, err := cli.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("failed to notify: %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func createPullsHandler(rc *checker.RepoChecker) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pulls := rc.PullRequests()
+		if pulls == nil {
+			pulls = []checker.PullRequest{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(pulls)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// healthz returns OK while repo-checker is running.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// createHandler returns the handler of the latest revision.
+// It returns the revision with the metadata of the commit in JSON if the client accepts it, or the raw hash otherwise.
 func createHandler(rc *checker.RepoChecker) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rev := rc.LatestRevision()
-		if len(rev) == 0 {
+		rev, ok := rc.Revision()
+		if !ok {
 			http.Error(w, "revision not found", http.StatusNotFound)
 			return
 		}
-		_, err := w.Write([]byte(rev))
+		if len(rev.Tag) != 0 {
+			w.Header().Set(checker.TagHeader, rev.Tag)
+		}
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(rev)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		_, err := w.Write([]byte(rev.Hash))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cybozu-go/website-operator/checker"
+	"github.com/cybozu-go/well"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// sharedMain runs repo-checker for all the WebSites that use the shared repo-checker.
+// The operator registers the repository of each WebSite by the query parameters of the requests.
+// Only the operator can register the repositories by the token, so that other Pods cannot make repo-checker access arbitrary URLs.
+func sharedMain(ctx context.Context) error {
+	token := os.Getenv(checker.SharedTokenEnv)
+	if len(token) == 0 {
+		return errors.New(checker.SharedTokenEnv + " is required in the shared mode")
+	}
+
+	mrc := checker.NewMultiRepoChecker(ctx, config.interval, config.jitter, config.ttl)
+	mrc.SetCommitMetadata(config.commitMetadata)
+	mrc.SetMaxSites(config.maxSites)
+	well.Go(mrc.Run)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		rc, ok := registerSite(mrc, token, w, r)
+		if !ok {
+			return
+		}
+		createHandler(rc)(w, r)
+	})
+	mux.HandleFunc("GET /repos/{namespace}/{name}/pulls", func(w http.ResponseWriter, r *http.Request) {
+		rc, ok := registerSite(mrc, token, w, r)
+		if !ok {
+			return
+		}
+		createPullsHandler(rc)(w, r)
+	})
+	mux.HandleFunc("/healthz", healthz)
+	// the repositories are registered by the operator, so the shared repo-checker is ready at the start
+	mux.HandleFunc("/readyz", healthz)
+	mux.Handle("/metrics", promhttp.Handler())
+	serv := &well.HTTPServer{
+		Server: &http.Server{
+			Addr:    config.listenAddr,
+			Handler: mux,
+		},
+	}
+
+	err := serv.ListenAndServe()
+	if err != nil {
+		return err
+	}
+	err = well.Wait()
+
+	if err != nil && !well.IsSignaled(err) {
+		return err
+	}
+
+	return nil
+}
+
+// registerSite registers the repository in the query parameters for the WebSite in the path.
+func registerSite(mrc *checker.MultiRepoChecker, token string, w http.ResponseWriter, r *http.Request) (*checker.RepoChecker, bool) {
+	if !checker.VerifySharedToken(r, token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	q := r.URL.Query()
+	reg := checker.Registration{
+		RepoURL: q.Get("repoURL"),
+		Branch:  q.Get("branch"),
+		Tag:     q.Get("tag"),
+	}
+	if len(reg.RepoURL) == 0 {
+		http.Error(w, "repoURL is required", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(reg.Branch) == 0 {
+		reg.Branch = "main"
+	}
+	if interval := q.Get("interval"); len(interval) != 0 {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		if d <= 0 {
+			http.Error(w, "interval should be positive", http.StatusBadRequest)
+			return nil, false
+		}
+		reg.Interval = d
+	}
+	rc, err := mrc.Register(r.PathValue("namespace")+"/"+r.PathValue("name"), reg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return nil, false
+	}
+	return rc, true
+}
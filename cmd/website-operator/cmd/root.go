@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/cybozu-go/website-operator"
+	"github.com/cybozu-go/website-operator/checker"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +20,12 @@ var config struct {
 	nginxContainerImage       string
 	repoCheckerContainerImage string
 	development               bool
+	notifyBindAddress         string
+	notifyURL                 string
+	revisionWatcherInterval   time.Duration
+	sharedRepoCheckerURL      string
+	configMapPolicy           []string
+	gitHubAPIURLs             []string
 }
 
 var rootCmd = &cobra.Command{
@@ -24,6 +34,21 @@ var rootCmd = &cobra.Command{
 	Short:   "WebSite Operator",
 	Long:    `WebSite Operator.`,
 
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if config.revisionWatcherInterval <= 0 {
+			return errors.New("--revision-watcher-interval should be positive")
+		}
+		for _, u := range config.gitHubAPIURLs {
+			if _, err := url.ParseRequestURI(u); err != nil {
+				return fmt.Errorf("invalid --allowed-github-api-urls: %w", err)
+			}
+		}
+		if len(config.sharedRepoCheckerURL) != 0 && len(os.Getenv(checker.SharedTokenEnv)) == 0 {
+			return errors.New(checker.SharedTokenEnv + " is required to use the shared repo-checker")
+		}
+		return nil
+	},
+
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
 		return subMain()
@@ -55,5 +80,11 @@ func init() {
 	fs.BoolVar(&config.enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	fs.StringVar(&config.nginxContainerImage, "nginx-container-image", nginx, "The container image name of nginx")
 	fs.StringVar(&config.repoCheckerContainerImage, "repochecker-container-image", repochecker, "The container image name of repo-checker")
+	fs.StringVar(&config.notifyBindAddress, "notify-bind-address", ":8082", "The address the endpoint to receive notifications from repo-checker binds to")
+	fs.StringVar(&config.notifyURL, "notify-url", "", "The URL of the endpoint to receive notifications from repo-checker. If empty, repo-checker does not notify the operator")
+	fs.DurationVar(&config.revisionWatcherInterval, "revision-watcher-interval", time.Minute, "The interval to poll repo-checkers for the latest revisions")
+	fs.StringVar(&config.sharedRepoCheckerURL, "shared-repo-checker-url", "", "The URL of the shared repo-checker. If empty, every WebSite has its own repo-checker. The token to access it is given by "+checker.SharedTokenEnv+" environment variable")
+	fs.StringArrayVar(&config.configMapPolicy, "allow-configmap-namespaces", nil, "The namespaces from which WebSites may read ConfigMaps in the form of TENANT=NAMESPACE[,NAMESPACE...]. \"*\" matches all namespaces. If not specified, WebSites can read ConfigMaps in any namespace")
+	fs.StringSliceVar(&config.gitHubAPIURLs, "allowed-github-api-urls", []string{"https://api.github.com"}, "The URLs of the GitHub API that WebSites may use to mint the installation tokens of GitHub Apps")
 	fs.BoolVar(&config.development, "development", false, "Zap development mode")
 }
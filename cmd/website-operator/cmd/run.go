@@ -8,11 +8,16 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
 	"github.com/cybozu-go/website-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -38,6 +43,12 @@ func subMain() error {
 	}
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	configMapPolicy, err := controllers.ParseConfigMapPolicy(config.configMapPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid ConfigMap policy")
+		return err
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
@@ -46,6 +57,23 @@ func subMain() error {
 		WebhookServer: webhook.NewServer(webhook.Options{
 			Port: 9443,
 		}),
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				// only the nginx Pods are watched to report the builds, not to keep all the Pods in the cluster in memory
+				&corev1.Pod{}: {
+					Label: labels.SelectorFromSet(labels.Set{
+						controllers.ManagedByKey: controllers.OperatorName,
+						controllers.AppNameKey:   controllers.AppNameNginx,
+					}),
+				},
+			},
+		},
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// Secrets are not cached not to keep all the Secrets in the cluster in memory
+				DisableFor: []client.Object{&corev1.Secret{}},
+			},
+		},
 		HealthProbeBindAddress: config.probeAddr,
 		LeaderElection:         config.enableLeaderElection,
 		LeaderElectionID:       config.leaderElectionID,
@@ -57,16 +85,29 @@ func subMain() error {
 
 	if err = controllers.NewWebSiteReconciler(
 		mgr.GetClient(),
+		mgr.GetAPIReader(),
 		ctrl.Log.WithName("controllers").WithName("WebSite"),
 		mgr.GetScheme(),
 		config.nginxContainerImage,
 		config.repoCheckerContainerImage,
 		os.Getenv("POD_NAMESPACE"),
-		&controllers.RepoCheckerClient{},
+		&controllers.RepoCheckerClient{SharedURL: config.sharedRepoCheckerURL, SharedToken: os.Getenv(checker.SharedTokenEnv)},
+		config.notifyBindAddress,
+		config.notifyURL,
+		config.revisionWatcherInterval,
+		configMapPolicy,
+		config.gitHubAPIURLs,
 	).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WebSite")
 		return err
 	}
+	// webhooks require certificates, so they can be disabled in development environments
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&websitev1beta1.WebSite{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WebSite")
+			return err
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
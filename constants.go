@@ -2,7 +2,13 @@ package website
 
 const (
 	DefaultNginxContainerImage = "ghcr.io/zoetrope/nginx:1.28.0"
-	WebSiteIndexField          = ".status.ready"
+	WebSiteIndexField          = ".status.conditions.revisionResolved"
+	// ConfigMapIndexField indexes WebSites by the ConfigMaps they refer to in the form of "namespace/name".
+	ConfigMapIndexField = ".spec.configMaps"
+	// SecretIndexField indexes WebSites by the Secrets they refer to in the form of "namespace/name".
+	SecretIndexField = ".spec.secrets"
+	// BuildTemplateIndexField indexes WebSites by the BuildTemplate they use.
+	BuildTemplateIndexField = ".spec.buildTemplate.name"
 )
 
 var DefaultRepoCheckerContainerImage = "ghcr.io/zoetrope/repo-checker:" + Version
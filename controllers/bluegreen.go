@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	ColorKey   = "website.zoetrope.github.io/color"
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+	// ColorRollingUpdate is the color of the pods of the RollingUpdate strategy while switching to the BlueGreen strategy.
+	ColorRollingUpdate = "rolling-update"
+
+	// AnnRolloutFailed is set to the Deployment whose rollout has failed. Its value is the reason of the failure.
+	AnnRolloutFailed = "website.zoetrope.github.io/rollout-failed"
+)
+
+func isBlueGreen(webSite *websitev1beta1.WebSite) bool {
+	return webSite.Spec.Strategy == websitev1beta1.BlueGreenStrategy
+}
+
+func colorDeploymentName(webSite *websitev1beta1.WebSite, color string) string {
+	return webSite.Name + "-" + color
+}
+
+// activeDeploymentName returns the name of the nginx Deployment that serves the site.
+func activeDeploymentName(webSite *websitev1beta1.WebSite) string {
+	if len(webSite.Status.ActiveColor) != 0 {
+		return colorDeploymentName(webSite, webSite.Status.ActiveColor)
+	}
+	return webSite.Name
+}
+
+// reconcileBlueGreen deploys the revision to the inactive Deployment, and switches the Service to it once it becomes ready.
+// It returns the revision served by the active Deployment.
+func (r *WebSiteReconciler) reconcileBlueGreen(ctx context.Context, webSite *websitev1beta1.WebSite, revision, artifact string, buildScriptHash, nginxConfHash string) (string, error) {
+	log := r.log.WithValues("website", webSite.Name)
+
+	podTemplate, err := r.makeNginxPodTemplate(ctx, webSite, revision, artifact, buildScriptHash, nginxConfHash)
+	if err != nil {
+		return "", err
+	}
+
+	active := webSite.Status.ActiveColor
+	if len(active) != 0 {
+		deployment := &appsv1.Deployment{}
+		err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: colorDeploymentName(webSite, active)}, deployment)
+		if apierrors.IsNotFound(err) {
+			log.Info("active Deployment is not found", "color", active)
+			active = ""
+			webSite.Status.ActiveColor = ""
+		} else if err != nil {
+			return "", err
+		} else if equality.Semantic.DeepDerivative(withColor(podTemplate, active), &deployment.Spec.Template) {
+			// the active Deployment is up to date
+			_, err := r.reconcileColorDeployment(ctx, webSite, active, withColor(podTemplate, active))
+			if err != nil {
+				return "", err
+			}
+			err = r.scaleDownDeployment(ctx, webSite, colorDeploymentName(webSite, otherColor(active)))
+			if err != nil {
+				return "", err
+			}
+			setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionTrue, ReasonSucceeded, "revision "+revision+" is served by "+deployment.Name)
+			return revision, r.updateNginxConditions(ctx, webSite, deployment.Name, revision)
+		}
+	}
+
+	candidate := otherColor(active)
+	candidateName := colorDeploymentName(webSite, candidate)
+	candidateTemplate := withColor(podTemplate, candidate)
+
+	if len(active) == 0 {
+		ok, err := r.labelRollingUpdatePods(ctx, webSite)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionUnknown, ReasonRollingOut, "waiting for the pods of "+webSite.Name+" to be labeled")
+			return r.servedRevision(ctx, webSite, candidateName, revision)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: candidateName}, deployment)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	if msg, ok := deployment.Annotations[AnnRolloutFailed]; ok && equality.Semantic.DeepDerivative(candidateTemplate, &deployment.Spec.Template) {
+		// do not retry the failed rollout until the template is changed
+		setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionFalse, ReasonRolledBack, rolledBackMessage(webSite, revision, msg))
+		return r.servedRevision(ctx, webSite, candidateName, revision)
+	}
+
+	deployment, err = r.reconcileColorDeployment(ctx, webSite, candidate, candidateTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	if isRolloutCompleted(deployment) {
+		webSite.Status.ActiveColor = candidate
+		_, err := r.reconcileNginxService(ctx, webSite)
+		if err != nil {
+			return "", err
+		}
+		log.Info("switch Service For Nginx", "color", candidate, "revision", revision)
+
+		if len(active) != 0 {
+			err = r.scaleDownDeployment(ctx, webSite, colorDeploymentName(webSite, active))
+		} else {
+			// the Deployment for the RollingUpdate strategy is no longer used
+			err = r.deleteDeployment(ctx, webSite, webSite.Name)
+		}
+		if err != nil {
+			return "", err
+		}
+		setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionTrue, ReasonSucceeded, "revision "+revision+" is served by "+candidateName)
+		return revision, r.updateNginxConditions(ctx, webSite, candidateName, revision)
+	}
+
+	msg, err := r.rolloutFailureMessage(ctx, webSite, deployment, revision)
+	if err != nil {
+		return "", err
+	}
+	if len(msg) != 0 {
+		log.Info("rollout has failed", "color", candidate, "revision", revision, "reason", msg)
+		deployment.Annotations[AnnRolloutFailed] = msg
+		deployment.Spec.Replicas = ptr.To[int32](0)
+		err := r.client.Update(ctx, deployment)
+		if err != nil {
+			return "", err
+		}
+		setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionFalse, ReasonRolledBack, rolledBackMessage(webSite, revision, msg))
+		return r.servedRevision(ctx, webSite, candidateName, revision)
+	}
+
+	setCondition(webSite, websitev1beta1.ConditionRolloutSucceeded, metav1.ConditionUnknown, ReasonRollingOut, "waiting for "+candidateName+" to become ready")
+	return r.servedRevision(ctx, webSite, candidateName, revision)
+}
+
+// labelRollingUpdatePods labels the pods of the RollingUpdate strategy with ColorRollingUpdate and makes the Service select them,
+// so that the candidate does not receive the requests before the first switch to the BlueGreen strategy.
+// It returns true once the candidate can be rolled out.
+func (r *WebSiteReconciler) labelRollingUpdatePods(ctx context.Context, webSite *websitev1beta1.WebSite) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name}, deployment)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !deployment.DeletionTimestamp.IsZero() {
+		return true, nil
+	}
+
+	if deployment.Spec.Template.Labels[ColorKey] != ColorRollingUpdate {
+		if deployment.Spec.Template.Labels == nil {
+			deployment.Spec.Template.Labels = make(map[string]string)
+		}
+		deployment.Spec.Template.Labels[ColorKey] = ColorRollingUpdate
+		err := r.client.Update(ctx, deployment)
+		if err != nil {
+			return false, err
+		}
+		r.log.Info("label the pods of RollingUpdate strategy", "website", webSite.Name)
+		return false, nil
+	}
+	if !isRolloutCompleted(deployment) {
+		for _, cond := range deployment.Status.Conditions {
+			if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse && cond.Reason == "ProgressDeadlineExceeded" {
+				// the pods do not serve the site anyway
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	service := &corev1.Service{}
+	err = r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name}, service)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if service.Spec.Selector[ColorKey] != ColorRollingUpdate {
+		if service.Spec.Selector == nil {
+			service.Spec.Selector = make(map[string]string)
+		}
+		service.Spec.Selector[ColorKey] = ColorRollingUpdate
+		err := r.client.Update(ctx, service)
+		if err != nil {
+			return false, err
+		}
+		r.log.Info("select the pods of RollingUpdate strategy", "website", webSite.Name)
+	}
+	return true, nil
+}
+
+// servedRevision reports the conditions of the active Deployment and returns the revision served by it.
+// If no Deployment serves the site yet, the conditions of the candidate are reported instead.
+func (r *WebSiteReconciler) servedRevision(ctx context.Context, webSite *websitev1beta1.WebSite, candidateName, revision string) (string, error) {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: activeDeploymentName(webSite)}, deployment)
+	if apierrors.IsNotFound(err) {
+		return "", r.updateNginxConditions(ctx, webSite, candidateName, revision)
+	}
+	if err != nil {
+		return "", err
+	}
+	return webSite.Status.Revision, r.updateNginxConditions(ctx, webSite, deployment.Name, webSite.Status.Revision)
+}
+
+func (r *WebSiteReconciler) reconcileColorDeployment(ctx context.Context, webSite *websitev1beta1.WebSite, color string, podTemplate *corev1.PodTemplateSpec) (*appsv1.Deployment, error) {
+	log := r.log.WithValues("website", webSite.Name)
+	deployment := &appsv1.Deployment{}
+	deployment.SetNamespace(webSite.Namespace)
+	deployment.SetName(colorDeploymentName(webSite, color))
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, deployment, func() error {
+		setStandardLabels(AppNameNginx, &deployment.ObjectMeta)
+		deployment.Labels[ColorKey] = color
+		if deployment.Annotations == nil {
+			deployment.Annotations = make(map[string]string)
+		}
+		deployment.Spec.Replicas = &webSite.Spec.Replicas
+		deployment.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				ManagedByKey: OperatorName,
+				AppNameKey:   AppNameNginx,
+				InstanceKey:  webSite.Name,
+				ColorKey:     color,
+			},
+		}
+		if !equality.Semantic.DeepDerivative(podTemplate, &deployment.Spec.Template) {
+			deployment.Spec.Template = *podTemplate
+			delete(deployment.Annotations, AnnRolloutFailed)
+		}
+
+		return ctrl.SetControllerReference(webSite, deployment, r.scheme)
+	})
+	if err != nil {
+		log.Error(err, "unable to create-or-update Deployment For Nginx", "color", color)
+		return nil, err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile Deployment For Nginx successfully", "op", op, "color", color)
+	}
+	return deployment, nil
+}
+
+func (r *WebSiteReconciler) scaleDownDeployment(ctx context.Context, webSite *websitev1beta1.WebSite, name string) error {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: name}, deployment)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		return nil
+	}
+	deployment.Spec.Replicas = ptr.To[int32](0)
+	err = r.client.Update(ctx, deployment)
+	if err != nil {
+		return err
+	}
+	r.log.Info("scale down Deployment For Nginx successfully", "website", webSite.Name, "name", name)
+	return nil
+}
+
+func (r *WebSiteReconciler) deleteDeployment(ctx context.Context, webSite *websitev1beta1.WebSite, name string) error {
+	deployment := &appsv1.Deployment{}
+	deployment.SetNamespace(webSite.Namespace)
+	deployment.SetName(name)
+	err := r.client.Delete(ctx, deployment, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.log.Info("delete Deployment For Nginx successfully", "website", webSite.Name, "name", name)
+	return nil
+}
+
+// cleanupBlueGreen deletes the Deployments of the BlueGreen strategy once the Deployment for the RollingUpdate strategy becomes ready.
+func (r *WebSiteReconciler) cleanupBlueGreen(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	meta.RemoveStatusCondition(&webSite.Status.Conditions, websitev1beta1.ConditionRolloutSucceeded)
+	if len(webSite.Status.ActiveColor) == 0 {
+		return nil
+	}
+
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name}, deployment)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isRolloutCompleted(deployment) {
+		return nil
+	}
+
+	for _, color := range []string{ColorBlue, ColorGreen} {
+		err := r.deleteDeployment(ctx, webSite, colorDeploymentName(webSite, color))
+		if err != nil {
+			return err
+		}
+	}
+	webSite.Status.ActiveColor = ""
+	return nil
+}
+
+// rolloutFailureMessage returns the reason why the Deployment cannot become ready, or an empty string if it is still progressing.
+func (r *WebSiteReconciler) rolloutFailureMessage(ctx context.Context, webSite *websitev1beta1.WebSite, deployment *appsv1.Deployment, revision string) (string, error) {
+	for _, cond := range deployment.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse && cond.Reason == "ProgressDeadlineExceeded" {
+			return cond.Message, nil
+		}
+	}
+
+	pods := &corev1.PodList{}
+	err := r.client.List(ctx, pods, client.InNamespace(webSite.Namespace), client.MatchingLabels(deployment.Spec.Selector.MatchLabels))
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if !isCurrentBuildPod(&pod, deployment, revision) {
+			continue
+		}
+		if _, msg := buildFailure(&pod); len(msg) != 0 {
+			return msg, nil
+		}
+	}
+	return "", nil
+}
+
+func rolledBackMessage(webSite *websitev1beta1.WebSite, revision, reason string) string {
+	served := webSite.Status.Revision
+	if len(served) == 0 {
+		return fmt.Sprintf("rollout of revision %s has failed: %s", revision, reason)
+	}
+	return fmt.Sprintf("rollout of revision %s has failed and revision %s is still served: %s", revision, served, reason)
+}
+
+func otherColor(color string) string {
+	if color == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+func withColor(podTemplate *corev1.PodTemplateSpec, color string) *corev1.PodTemplateSpec {
+	t := podTemplate.DeepCopy()
+	t.Labels[ColorKey] = color
+	return t
+}
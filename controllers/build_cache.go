@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	AppNameBuildCache = "build-cache"
+	BuildCacheSuffix  = "-build-cache"
+	// BuildCachePath is the directory where the build cache is mounted in the build container.
+	BuildCachePath = "/build-cache"
+	// AnnPurgeBuildCache is the annotation of the WebSite to purge the build cache.
+	// The cache is purged before the next build every time the value is changed.
+	AnnPurgeBuildCache = "website.zoetrope.github.io/purge-build-cache"
+)
+
+// purgeBuildCacheScript removes the contents of the build cache unless it has been purged for the token.
+const purgeBuildCacheScript = `set -e
+if [ "$(cat ` + BuildCachePath + `/.purge-token 2>/dev/null)" != "${PURGE_TOKEN}" ]; then
+  find ` + BuildCachePath + ` -mindepth 1 -delete
+  echo -n "${PURGE_TOKEN}" > ` + BuildCachePath + `/.purge-token
+fi
+`
+
+// usesBuildCache returns true if the build uses the build cache.
+// The cache is used only if the site is built by a Job with Artifacts,
+// because otherwise every replica of nginx builds the site and they would purge and write the shared cache at the same time.
+func usesBuildCache(webSite *websitev1beta1.WebSite) bool {
+	return webSite.Spec.BuildCache != nil && webSite.Spec.Artifacts != nil
+}
+
+func makeBuildCacheVolume(webSite *websitev1beta1.WebSite) corev1.Volume {
+	return corev1.Volume{
+		Name: "build-cache",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: webSite.Name + BuildCacheSuffix,
+			},
+		},
+	}
+}
+
+func makeBuildCacheVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		MountPath: BuildCachePath,
+		Name:      "build-cache",
+	}
+}
+
+// makeBuildCacheEnv returns the environment variables that point the caches of the common build tools to the build cache.
+func makeBuildCacheEnv() []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name:  "BUILD_CACHE",
+			Value: BuildCachePath,
+		},
+		{
+			// go-build, pip, yarn and so on
+			Name:  "XDG_CACHE_HOME",
+			Value: BuildCachePath,
+		},
+		{
+			Name:  "npm_config_cache",
+			Value: BuildCachePath + "/npm",
+		},
+		{
+			Name:  "GOMODCACHE",
+			Value: BuildCachePath + "/go/mod",
+		},
+	}
+}
+
+// makePurgeBuildCacheContainer returns the container that purges the build cache before the build.
+// It is needed only if the purge of the cache has been requested.
+func makePurgeBuildCacheContainer(webSite *websitev1beta1.WebSite) (corev1.Container, bool) {
+	token := webSite.Annotations[AnnPurgeBuildCache]
+	if !usesBuildCache(webSite) || len(token) == 0 {
+		return corev1.Container{}, false
+	}
+	return corev1.Container{
+		Name:    "purge-build-cache",
+		Image:   webSite.Spec.BuildImage,
+		Command: []string{"/bin/sh", "-c", purgeBuildCacheScript},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser: ptr.To[int64](10000),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			makeBuildCacheVolumeMount(),
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "PURGE_TOKEN",
+				Value: token,
+			},
+		},
+	}, true
+}
+
+// reconcileBuildCachePVC creates the PersistentVolumeClaim for the build cache, and deletes it if it is no longer used.
+func (r *WebSiteReconciler) reconcileBuildCachePVC(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	if !usesBuildCache(webSite) {
+		return r.deleteOwnedObject(ctx, webSite, webSite.Name+BuildCacheSuffix, &corev1.PersistentVolumeClaim{})
+	}
+	log := r.log.WithValues("website", webSite.Name)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.SetNamespace(webSite.Namespace)
+	pvc.SetName(webSite.Name + BuildCacheSuffix)
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, pvc, func() error {
+		setStandardLabels(AppNameBuildCache, &pvc.ObjectMeta)
+		pvc.Labels[InstanceKey] = webSite.Name
+
+		// most of the spec of PersistentVolumeClaim is immutable
+		if pvc.CreationTimestamp.IsZero() {
+			storage := webSite.Spec.BuildCache
+			pvc.Spec.StorageClassName = storage.StorageClassName
+			pvc.Spec.AccessModes = storage.AccessModes
+			if len(pvc.Spec.AccessModes) == 0 {
+				pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+			}
+			size := storage.Size
+			if size.IsZero() {
+				size = resource.MustParse("5Gi")
+			}
+			pvc.Spec.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			}
+		}
+		return ctrl.SetControllerReference(webSite, pvc, r.scheme)
+	})
+	if err != nil {
+		log.Error(err, "unable to reconcile build cache PersistentVolumeClaim")
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile build cache PersistentVolumeClaim successfully", "op", op)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"slices"
+	"strings"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	AppNameBuild     = "build"
+	AppNameArtifacts = "artifacts"
+	ArtifactsSuffix  = "-artifacts"
+	ArtifactsPath    = "/artifacts"
+	// AnnRetryBuild is the annotation of the WebSite to retry the failed build.
+	// The failed build Job is created again every time the value is changed.
+	AnnRetryBuild = "website.zoetrope.github.io/retry-build"
+)
+
+// buildJobScript runs the build script into a temporary directory and publishes it as an artifact atomically.
+// Artifacts other than the new one, the one being served and the retained ones are removed.
+const buildJobScript = `set -e
+rm -rf "${OUTPUT}"
+mkdir -p "${OUTPUT}"
+/build/` + BuildScriptName + `.sh
+rm -rf "` + ArtifactsPath + `/${ARTIFACT}"
+mv "${OUTPUT}" "` + ArtifactsPath + `/${ARTIFACT}"
+for dir in ` + ArtifactsPath + `/*; do
+  name=$(basename "${dir}")
+  case " ${ARTIFACT} ${DEPLOYED_ARTIFACT} ${RETAINED_ARTIFACTS} " in
+    *" ${name} "*) ;;
+    *) rm -rf "${dir}" ;;
+  esac
+done
+`
+
+// artifactKey returns the name of the artifact built from the revision and the build script.
+func artifactKey(revision, buildScriptHash string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(revision+"/"+buildScriptHash)))[:10]
+}
+
+// artifactRetention returns the number of the artifacts kept in the storage.
+func artifactRetention(webSite *websitev1beta1.WebSite) int {
+	if webSite.Spec.Artifacts.Retention < 1 {
+		return 2
+	}
+	return int(webSite.Spec.Artifacts.Retention)
+}
+
+// hasArtifact returns true if the artifact is kept in the storage.
+func hasArtifact(webSite *websitev1beta1.WebSite, artifact string) bool {
+	return slices.ContainsFunc(webSite.Status.Artifacts, func(a websitev1beta1.ArtifactStatus) bool {
+		return a.Name == artifact
+	})
+}
+
+// retainedArtifacts returns the artifacts kept in the storage together with a new artifact.
+func retainedArtifacts(webSite *websitev1beta1.WebSite, artifact string) []string {
+	var names []string
+	for _, a := range webSite.Status.Artifacts {
+		if len(names) >= artifactRetention(webSite)-1 {
+			break
+		}
+		if a.Name != artifact {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+// recordArtifact moves the artifact to the head of the status, and forgets the artifacts beyond the retention.
+// The time of the build of an artifact already in the status is kept.
+func recordArtifact(webSite *websitev1beta1.WebSite, artifact websitev1beta1.ArtifactStatus) {
+	artifacts := []websitev1beta1.ArtifactStatus{artifact}
+	for _, a := range webSite.Status.Artifacts {
+		if a.Name == artifact.Name {
+			artifacts[0].BuiltAt = a.BuiltAt
+			continue
+		}
+		artifacts = append(artifacts, a)
+	}
+	if len(artifacts) > artifactRetention(webSite) {
+		artifacts = artifacts[:artifactRetention(webSite)]
+	}
+	webSite.Status.Artifacts = artifacts
+}
+
+func makeArtifactsVolume(webSite *websitev1beta1.WebSite) corev1.Volume {
+	return corev1.Volume{
+		Name: "artifacts",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: webSite.Name + ArtifactsSuffix,
+			},
+		},
+	}
+}
+
+func (r *WebSiteReconciler) makeFetchContainer(revision, artifact string) corev1.Container {
+	return corev1.Container{
+		Name:    "fetch",
+		Image:   r.nginxContainerImage,
+		Command: []string{"/bin/sh", "-c", `cp -R "` + ArtifactsPath + `/${ARTIFACT}/." /data/`},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser: ptr.To[int64](10000),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				MountPath: ArtifactsPath,
+				Name:      "artifacts",
+				ReadOnly:  true,
+			},
+			{
+				MountPath: "/data",
+				Name:      "data",
+			},
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "REVISION",
+				Value: revision,
+			},
+			{
+				Name:  "ARTIFACT",
+				Value: artifact,
+			},
+		},
+	}
+}
+
+// reconcileBuild builds the revision by a Job and returns the revision and the artifact that nginx should serve.
+// While the build is in progress or if it has failed, the artifact currently being served is returned.
+func (r *WebSiteReconciler) reconcileBuild(ctx context.Context, webSite *websitev1beta1.WebSite, revision, buildScriptHash string) (string, string, error) {
+	err := r.reconcileArtifactsPVC(ctx, webSite)
+	if err != nil {
+		return "", "", err
+	}
+
+	deployedRevision, deployedArtifact, err := r.getDeployedArtifact(ctx, webSite)
+	if err != nil {
+		return "", "", err
+	}
+
+	artifact := artifactKey(revision, buildScriptHash)
+	if artifact == deployedArtifact || hasArtifact(webSite, artifact) {
+		if artifact != deployedArtifact {
+			r.log.Info("reuse the cached artifact", "website", webSite.Name, "revision", revision, "artifact", artifact)
+		}
+		recordArtifact(webSite, websitev1beta1.ArtifactStatus{Name: artifact, Revision: revision, BuiltAt: metav1.Now()})
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionTrue, ReasonSucceeded, "revision "+revision+" has been built")
+		return revision, artifact, r.cleanupBuildJobs(ctx, webSite, artifact, deployedArtifact)
+	}
+
+	job, err := r.reconcileBuildJob(ctx, webSite, revision, artifact, deployedArtifact)
+	if err != nil {
+		return "", "", err
+	}
+	err = r.cleanupBuildJobs(ctx, webSite, artifact, deployedArtifact)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		builtAt := metav1.Now()
+		if job.Status.CompletionTime != nil {
+			builtAt = *job.Status.CompletionTime
+		}
+		recordArtifact(webSite, websitev1beta1.ArtifactStatus{Name: artifact, Revision: revision, BuiltAt: builtAt})
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionTrue, ReasonSucceeded, "revision "+revision+" has been built")
+		return revision, artifact, nil
+	case isJobTimedOut(job):
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, ReasonBuildTimedOut, "build Job "+job.Name+" has exceeded the timeout; change the "+AnnRetryBuild+" annotation to retry")
+	case isJobFailed(job):
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, ReasonBuildFailed, "build Job "+job.Name+" has failed; change the "+AnnRetryBuild+" annotation to retry")
+	default:
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionUnknown, ReasonBuilding, "waiting for the build of revision "+revision)
+	}
+	return deployedRevision, deployedArtifact, nil
+}
+
+func (r *WebSiteReconciler) reconcileArtifactsPVC(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	log := r.log.WithValues("website", webSite.Name)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.SetNamespace(webSite.Namespace)
+	pvc.SetName(webSite.Name + ArtifactsSuffix)
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, pvc, func() error {
+		setStandardLabels(AppNameArtifacts, &pvc.ObjectMeta)
+		pvc.Labels[InstanceKey] = webSite.Name
+
+		// most of the spec of PersistentVolumeClaim is immutable
+		if pvc.CreationTimestamp.IsZero() {
+			storage := webSite.Spec.Artifacts
+			pvc.Spec.StorageClassName = storage.StorageClassName
+			pvc.Spec.AccessModes = storage.AccessModes
+			if len(pvc.Spec.AccessModes) == 0 {
+				pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+			}
+			size := storage.Size
+			if size.IsZero() {
+				size = resource.MustParse("1Gi")
+			}
+			pvc.Spec.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			}
+		}
+		return ctrl.SetControllerReference(webSite, pvc, r.scheme)
+	})
+	if err != nil {
+		log.Error(err, "unable to reconcile artifacts PersistentVolumeClaim")
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile artifacts PersistentVolumeClaim successfully", "op", op)
+	}
+	return nil
+}
+
+// getDeployedArtifact returns the revision and the artifact in the nginx Deployment.
+func (r *WebSiteReconciler) getDeployedArtifact(ctx context.Context, webSite *websitev1beta1.WebSite) (string, string, error) {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: activeDeploymentName(webSite)}, deployment)
+	if apierrors.IsNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	artifact := deployment.Spec.Template.Annotations[AnnArtifact]
+	if len(artifact) == 0 {
+		return "", "", nil
+	}
+	for _, c := range deployment.Spec.Template.Spec.InitContainers {
+		for _, env := range c.Env {
+			if env.Name == "REVISION" {
+				return env.Value, artifact, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+func (r *WebSiteReconciler) reconcileBuildJob(ctx context.Context, webSite *websitev1beta1.WebSite, revision, artifact, deployedArtifact string) (*batchv1.Job, error) {
+	log := r.log.WithValues("website", webSite.Name)
+
+	job := &batchv1.Job{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name + "-build-" + artifact}, job)
+	if err == nil {
+		if !isJobFailed(job) || job.Annotations[AnnRetryBuild] == webSite.Annotations[AnnRetryBuild] {
+			return job, nil
+		}
+		// a Job cannot be restarted, so the failed one is deleted and created again when it is gone
+		if job.DeletionTimestamp == nil {
+			err = r.client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "unable to delete failed build Job")
+				return nil, err
+			}
+			log.Info("delete failed build Job to retry", "job", job.Name)
+		}
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: job.Namespace, Name: job.Name}}, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	job.SetNamespace(webSite.Namespace)
+	job.SetName(webSite.Name + "-build-" + artifact)
+	setStandardLabels(AppNameBuild, &job.ObjectMeta)
+	job.Labels[InstanceKey] = webSite.Name
+	job.Annotations = map[string]string{
+		AnnArtifact: artifact,
+	}
+	if token, ok := webSite.Annotations[AnnRetryBuild]; ok {
+		job.Annotations[AnnRetryBuild] = token
+	}
+
+	template := &job.Spec.Template
+	template.Labels = map[string]string{
+		ManagedByKey: OperatorName,
+		AppNameKey:   AppNameBuild,
+		InstanceKey:  webSite.Name,
+	}
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if build := webSite.Spec.Build; build != nil {
+		job.Spec.BackoffLimit = build.BackoffLimit
+		if build.Timeout != nil {
+			job.Spec.ActiveDeadlineSeconds = ptr.To(timeoutSeconds(build.Timeout.Duration))
+		}
+	}
+	template.Spec.Volumes = append(template.Spec.Volumes, getVolumeOrEmptyDir(webSite, "tmp"))
+	template.Spec.Volumes = append(template.Spec.Volumes, makeBuildVolumes(webSite)...)
+	template.Spec.Volumes = append(template.Spec.Volumes, makeArtifactsVolume(webSite))
+	if webSite.Spec.DeployKeySecretName != nil {
+		template.Spec.Volumes = append(template.Spec.Volumes, makeDeployKeyVolume(webSite))
+	}
+	if webSite.Spec.GitAuth != nil {
+		template.Spec.Volumes = append(template.Spec.Volumes, makeGitAuthVolume(webSite))
+	}
+	template.Spec.SecurityContext = &corev1.PodSecurityContext{
+		FSGroup:             ptr.To[int64](10000),
+		FSGroupChangePolicy: ptr.To(corev1.FSGroupChangeOnRootMismatch),
+	}
+
+	buildContainer := makeBuildContainer(webSite, revision, corev1.VolumeMount{MountPath: ArtifactsPath, Name: "artifacts"}, ArtifactsPath+"/"+artifact+".tmp")
+	buildContainer.Command = []string{"/bin/bash", "-c", buildJobScript}
+	buildContainer.Env = append(buildContainer.Env,
+		corev1.EnvVar{
+			Name:  "ARTIFACT",
+			Value: artifact,
+		},
+		corev1.EnvVar{
+			Name:  "DEPLOYED_ARTIFACT",
+			Value: deployedArtifact,
+		},
+		corev1.EnvVar{
+			Name:  "RETAINED_ARTIFACTS",
+			Value: strings.Join(retainedArtifacts(webSite, artifact), " "),
+		},
+	)
+	if purgeContainer, ok := makePurgeBuildCacheContainer(webSite); ok {
+		template.Spec.InitContainers = append(template.Spec.InitContainers, purgeContainer)
+	}
+	template.Spec.Containers = append(template.Spec.Containers, buildContainer)
+	for _, secret := range webSite.Spec.ImagePullSecrets {
+		template.Spec.ImagePullSecrets = append(template.Spec.ImagePullSecrets, secret)
+	}
+
+	err = ctrl.SetControllerReference(webSite, job, r.scheme)
+	if err != nil {
+		return nil, err
+	}
+	err = r.client.Create(ctx, job)
+	if err != nil {
+		log.Error(err, "unable to create build Job")
+		return nil, err
+	}
+
+	log.Info("create build Job successfully", "job", job.Name, "revision", revision)
+	return job, nil
+}
+
+// cleanupBuildJobs deletes the build Jobs for the WebSite except for the ones building the given artifacts.
+// The Job of the deployed artifact is kept so that its build log can be read.
+func (r *WebSiteReconciler) cleanupBuildJobs(ctx context.Context, webSite *websitev1beta1.WebSite, artifacts ...string) error {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(ctx, jobs, client.InNamespace(webSite.Namespace), client.MatchingLabels{
+		ManagedByKey: OperatorName,
+		AppNameKey:   AppNameBuild,
+		InstanceKey:  webSite.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs.Items {
+		if slices.Contains(artifacts, job.Annotations[AnnArtifact]) || job.DeletionTimestamp != nil {
+			continue
+		}
+		err := r.client.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
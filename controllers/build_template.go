@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/cybozu-go/website-operator"
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// applyBuildTemplate fills the build image, the scripts and nginx.conf that the WebSite does not specify by its BuildTemplate.
+// The WebSite is modified only in memory, and the parameters are replaced with the ones resolved by the defaults of the template.
+// It returns the ConfigMaps that the filled sources refer to, which are trusted because the BuildTemplate is set up by the administrators.
+func (r *WebSiteReconciler) applyBuildTemplate(ctx context.Context, webSite *websitev1beta1.WebSite) ([]websitev1beta1.ConfigMapSource, error) {
+	ref := webSite.Spec.BuildTemplate
+	if ref == nil {
+		return nil, nil
+	}
+
+	tmpl := &websitev1beta1.BuildTemplate{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: ref.Name}, tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	var trusted []websitev1beta1.ConfigMapSource
+	apply := func(source *websitev1beta1.DataSource) *websitev1beta1.DataSource {
+		if source.ConfigMap != nil {
+			trusted = append(trusted, *source.ConfigMap)
+		}
+		return source.DeepCopy()
+	}
+	if len(webSite.Spec.BuildImage) == 0 {
+		webSite.Spec.BuildImage = tmpl.Spec.BuildImage
+	}
+	if webSite.Spec.BuildScript.ConfigMap == nil && webSite.Spec.BuildScript.RawData == nil && tmpl.Spec.BuildScript != nil {
+		webSite.Spec.BuildScript = *apply(tmpl.Spec.BuildScript)
+	}
+	if webSite.Spec.AfterBuildScript == nil && tmpl.Spec.AfterBuildScript != nil {
+		webSite.Spec.AfterBuildScript = apply(tmpl.Spec.AfterBuildScript)
+	}
+	if webSite.Spec.NginxConf == nil && tmpl.Spec.NginxConf != nil {
+		webSite.Spec.NginxConf = apply(tmpl.Spec.NginxConf)
+	}
+	if len(webSite.Spec.BuildImage) == 0 {
+		return nil, fmt.Errorf("neither WebSite nor BuildTemplate %s specifies the build image", ref.Name)
+	}
+
+	params, err := resolveParameters(tmpl.Spec.Parameters, ref.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters for BuildTemplate %s: %w", ref.Name, err)
+	}
+	webSite.Spec.BuildTemplate = &websitev1beta1.BuildTemplateRef{
+		Name:       ref.Name,
+		Parameters: params,
+	}
+	return trusted, nil
+}
+
+// resolveParameters validates the values of the parameters and fills the defaults.
+func resolveParameters(defs []websitev1beta1.BuildTemplateParameter, values map[string]string) (map[string]string, error) {
+	params := make(map[string]string, len(defs))
+	for _, def := range defs {
+		if websitev1beta1.IsReservedParameterName(def.Name) {
+			return nil, fmt.Errorf("parameter %s is reserved by website-operator", def.Name)
+		}
+		value, ok := values[def.Name]
+		if !ok && def.Default != nil {
+			value, ok = *def.Default, true
+		}
+		if !ok {
+			if def.Required {
+				return nil, fmt.Errorf("parameter %s is required", def.Name)
+			}
+			continue
+		}
+
+		switch def.Type {
+		case websitev1beta1.ParameterTypeInteger:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("parameter %s should be an integer: %q", def.Name, value)
+			}
+		case websitev1beta1.ParameterTypeBoolean:
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("parameter %s should be a boolean: %q", def.Name, value)
+			}
+		}
+		params[def.Name] = value
+	}
+
+	for name := range values {
+		if !slices.ContainsFunc(defs, func(def websitev1beta1.BuildTemplateParameter) bool { return def.Name == name }) {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return params, nil
+}
+
+// makeBuildTemplateEnv returns the environment variables to pass the parameters of BuildTemplate to the scripts.
+func makeBuildTemplateEnv(webSite *websitev1beta1.WebSite) []corev1.EnvVar {
+	if webSite.Spec.BuildTemplate == nil {
+		return nil
+	}
+	params := webSite.Spec.BuildTemplate.Parameters
+	var env []corev1.EnvVar
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		env = append(env, corev1.EnvVar{
+			Name:  name,
+			Value: params[name],
+		})
+	}
+	return env
+}
+
+// buildTemplateParametersHash returns the hash of the parameters of BuildTemplate, or an empty string if there are none.
+func buildTemplateParametersHash(webSite *websitev1beta1.WebSite) string {
+	env := makeBuildTemplateEnv(webSite)
+	if len(env) == 0 {
+		return ""
+	}
+	h := md5.New()
+	for _, e := range env {
+		fmt.Fprintf(h, "%s=%q;", e.Name, e.Value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// referencedBuildTemplate returns the BuildTemplate referenced by the WebSite in the form of the key of the index.
+func referencedBuildTemplate(webSite *websitev1beta1.WebSite) []string {
+	if webSite.Spec.BuildTemplate == nil {
+		return nil
+	}
+	return []string{client.ObjectKey{Name: webSite.Spec.BuildTemplate.Name}.String()}
+}
+
+// templateConfigMapHandler returns the handler that enqueues the WebSites using the BuildTemplates referring to the ConfigMap.
+func (r *WebSiteReconciler) templateConfigMapHandler(logger logr.Logger) func(context.Context, client.Object) []reconcile.Request {
+	websiteHandler := r.referenceHandler(logger, website.BuildTemplateIndexField)
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		templates := &websitev1beta1.BuildTemplateList{}
+		err := r.client.List(ctx, templates, client.MatchingFields{website.ConfigMapIndexField: client.ObjectKeyFromObject(o).String()})
+		if err != nil {
+			logger.Error(err, "failed to list BuildTemplates")
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range templates.Items {
+			requests = append(requests, websiteHandler(ctx, &templates.Items[i])...)
+		}
+		return requests
+	}
+}
@@ -2,52 +2,182 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
 	"github.com/cybozu-go/well"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Revision represents a revision of the repository.
+type Revision struct {
+	// Hash is the commit hash
+	Hash string
+	// Tag is the name of the tag that points to the commit. It is empty unless the WebSite tracks tags.
+	Tag string
+	// Commit is the metadata of the commit. It is nil if repo-checker has not fetched it.
+	Commit *websitev1beta1.CommitInfo
+}
+
 type RevisionClient interface {
-	GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error)
+	GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (Revision, error)
+	GetPullRequests(ctx context.Context, webSite *websitev1beta1.WebSite) ([]checker.PullRequest, error)
+	// UsesSharedRepoChecker returns true if the WebSite is checked by the shared repo-checker instead of its own one.
+	UsesSharedRepoChecker(webSite *websitev1beta1.WebSite) bool
 }
 
 type RepoCheckerClient struct {
+	// SharedURL is the URL of the shared repo-checker. If empty, every WebSite has its own repo-checker.
+	SharedURL string
+	// SharedToken is the token to register the repositories to the shared repo-checker.
+	SharedToken string
+}
+
+func repoCheckerHost(webSite *websitev1beta1.WebSite) string {
+	return fmt.Sprintf("%s%s.%s.svc.cluster.local", webSite.Name, RepoCheckerSuffix, webSite.Namespace)
+}
+
+// UsesSharedRepoChecker implements RevisionClient.
+// The shared repo-checker cannot use the credentials and the webhook secrets of each site.
+func (c RepoCheckerClient) UsesSharedRepoChecker(webSite *websitev1beta1.WebSite) bool {
+	return len(c.SharedURL) != 0 &&
+		!webSite.Spec.DedicatedRepoChecker &&
+		webSite.Spec.DeployKeySecretName == nil &&
+		webSite.Spec.GitAuth == nil &&
+		webSite.Spec.WebhookSecret == nil
+}
+
+// pollInterval returns the interval for repo-checker to check the repository of the WebSite, or zero to use the default one.
+// It is clamped to MinPollInterval here too, because the admission webhook may be disabled.
+func pollInterval(webSite *websitev1beta1.WebSite) time.Duration {
+	if webSite.Spec.PollInterval == nil {
+		return 0
+	}
+	return max(webSite.Spec.PollInterval.Duration, websitev1beta1.MinPollInterval)
+}
+
+// newRequest returns the request to the API of repo-checker for the WebSite.
+func (c RepoCheckerClient) newRequest(ctx context.Context, webSite *websitev1beta1.WebSite, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.repoCheckerURL(webSite, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.UsesSharedRepoChecker(webSite) {
+		req.Header.Set("Authorization", "Bearer "+c.SharedToken)
+	}
+	return req, nil
+}
+
+// repoCheckerURL returns the URL of the API of repo-checker for the WebSite.
+// The shared repo-checker starts checking the repository given by the query parameters when it is requested.
+func (c RepoCheckerClient) repoCheckerURL(webSite *websitev1beta1.WebSite, path string) string {
+	if !c.UsesSharedRepoChecker(webSite) {
+		return fmt.Sprintf("http://%s/%s", repoCheckerHost(webSite), path)
+	}
+
+	q := url.Values{}
+	q.Set("repoURL", webSite.Spec.RepoURL)
+	q.Set("branch", webSite.Spec.Branch)
+	if len(webSite.Spec.Tag) != 0 {
+		q.Set("tag", webSite.Spec.Tag)
+	}
+	if interval := pollInterval(webSite); interval != 0 {
+		q.Set("interval", interval.String())
+	}
+	u := fmt.Sprintf("%s/repos/%s/%s", strings.TrimSuffix(c.SharedURL, "/"), url.PathEscape(webSite.Namespace), url.PathEscape(webSite.Name))
+	if len(path) != 0 {
+		u += "/" + path
+	}
+	return u + "?" + q.Encode()
 }
 
-func (c RepoCheckerClient) GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error) {
-	repoCheckerHost := fmt.Sprintf("%s%s.%s.svc.cluster.local", webSite.Name, RepoCheckerSuffix, webSite.Namespace)
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		fmt.Sprintf("http://%s/", repoCheckerHost),
-		nil,
-	)
+func (c RepoCheckerClient) GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (Revision, error) {
+	req, err := c.newRequest(ctx, webSite, "")
 	if err != nil {
-		return "", err
+		return Revision{}, err
 	}
+	req.Header.Set("Accept", "application/json")
 
-	cli := &well.HTTPClient{Client: &http.Client{}}
+	cli := &well.HTTPClient{Client: &http.Client{Timeout: repoCheckerTimeout}}
 	resp, err := cli.Do(req)
 	if err != nil {
-		return "", err
+		return Revision{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return "", errRevisionNotReady
+		return Revision{}, errRevisionNotReady
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to repo check: %s", resp.Status)
+		return Revision{}, fmt.Errorf("failed to repo check: %s", resp.Status)
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	// repo-checker older than the operator returns the raw hash even if JSON is requested
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return Revision{}, err
+		}
+		return Revision{
+			Hash: string(b),
+			Tag:  resp.Header.Get(checker.TagHeader),
+		}, nil
+	}
+
+	var rev checker.Revision
+	err = json.NewDecoder(resp.Body).Decode(&rev)
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{
+		Hash:   rev.Hash,
+		Tag:    rev.Tag,
+		Commit: commitInfo(&rev),
+	}, nil
+}
+
+// commitInfo returns the metadata of the commit to be shown in the status, or nil if they have not been fetched.
+func commitInfo(rev *checker.Revision) *websitev1beta1.CommitInfo {
+	if rev.Timestamp == nil {
+		return nil
+	}
+	subject, _, _ := strings.Cut(rev.Message, "\n")
+	return &websitev1beta1.CommitInfo{
+		Author:    rev.Author,
+		Message:   subject,
+		Timestamp: &metav1.Time{Time: *rev.Timestamp},
+	}
+}
+
+func (c RepoCheckerClient) GetPullRequests(ctx context.Context, webSite *websitev1beta1.WebSite) ([]checker.PullRequest, error) {
+	req, err := c.newRequest(ctx, webSite, "pulls")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(b), nil
+	cli := &well.HTTPClient{Client: &http.Client{Timeout: repoCheckerTimeout}}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get pull requests: %s", resp.Status)
+	}
+
+	var pulls []checker.PullRequest
+	err = json.NewDecoder(resp.Body).Decode(&pulls)
+	if err != nil {
+		return nil, err
+	}
+	return pulls, nil
 }
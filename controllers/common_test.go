@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("RepoCheckerClient", func() {
+	site := &websitev1beta1.WebSite{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "mysite"},
+		Spec: websitev1beta1.WebSiteSpec{
+			RepoURL: "https://github.com/zoetrope/honkit-sample.git",
+			Branch:  "main",
+		},
+	}
+
+	It("should get the revision with the metadata of the commit", func() {
+		committed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Accept") != "application/json" {
+				http.Error(w, "JSON is not requested", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(checker.Revision{
+				Hash:      "rev1",
+				Ref:       "refs/heads/main",
+				Author:    "alice",
+				Message:   "update the document\n\nfix typos",
+				Timestamp: &committed,
+				CheckedAt: time.Now(),
+			})
+		}))
+		defer serv.Close()
+
+		rev, err := RepoCheckerClient{SharedURL: serv.URL}.GetLatestRevision(context.Background(), site)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(rev.Hash).Should(Equal("rev1"))
+		Expect(rev.Commit).ShouldNot(BeNil())
+		Expect(rev.Commit.Author).Should(Equal("alice"))
+		Expect(rev.Commit.Message).Should(Equal("update the document"))
+		Expect(rev.Commit.Timestamp.Time).Should(BeTemporally("==", committed))
+	})
+
+	It("should get the raw revision from the old repo-checker", func() {
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(checker.TagHeader, "v1.0.0")
+			_, _ = w.Write([]byte("rev1"))
+		}))
+		defer serv.Close()
+
+		rev, err := RepoCheckerClient{SharedURL: serv.URL}.GetLatestRevision(context.Background(), site)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(rev).Should(Equal(Revision{Hash: "rev1", Tag: "v1.0.0"}))
+	})
+})
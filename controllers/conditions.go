@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the WebSite conditions
+const (
+	ReasonReady                 = "Ready"
+	ReasonRendered              = "Rendered"
+	ReasonBuildScriptError      = "BuildScriptError"
+	ReasonAfterBuildScriptError = "AfterBuildScriptError"
+	ReasonNginxConfError        = "NginxConfError"
+	ReasonConfigMapNotAllowed   = "ConfigMapNotAllowed"
+	ReasonBuildTemplateError    = "BuildTemplateError"
+	ReasonResolved              = "Resolved"
+	ReasonPinned                = "Pinned"
+	ReasonRepoCheckerError      = "RepoCheckerError"
+	ReasonGitAuthError          = "GitAuthError"
+	ReasonRevisionNotReady      = "RevisionNotReady"
+	ReasonRevisionCheckFailed   = "RevisionCheckFailed"
+	ReasonAvailable             = "Available"
+	ReasonUnavailable           = "Unavailable"
+	ReasonDeploymentError       = "DeploymentError"
+	ReasonServiceError          = "ServiceError"
+	ReasonRouteError            = "RouteError"
+	ReasonSucceeded             = "Succeeded"
+	ReasonBuilding              = "Building"
+	ReasonBuildFailed           = "BuildFailed"
+	ReasonBuildTimedOut         = "BuildTimedOut"
+	ReasonBuildCacheError       = "BuildCacheError"
+	ReasonBuildSecretsError     = "BuildSecretsError"
+	ReasonApplied               = "Applied"
+	ReasonApplyFailed           = "ApplyFailed"
+	ReasonNotConfigured         = "NotConfigured"
+	ReasonCompleted             = "Completed"
+	ReasonJobRunning            = "JobRunning"
+	ReasonJobFailed             = "JobFailed"
+	ReasonJobTimedOut           = "JobTimedOut"
+	ReasonJobError              = "JobError"
+	ReasonConditionMissing      = "ConditionMissing"
+	ReasonRollingOut            = "RollingOut"
+	ReasonRolledBack            = "RolledBack"
+)
+
+// stageConditions are the conditions that must be true for a WebSite to be ready.
+var stageConditions = []string{
+	websitev1beta1.ConditionConfigRendered,
+	websitev1beta1.ConditionRevisionResolved,
+	websitev1beta1.ConditionBuildSucceeded,
+	websitev1beta1.ConditionNginxAvailable,
+	websitev1beta1.ConditionExtraResourcesApplied,
+	websitev1beta1.ConditionAfterBuildCompleted,
+}
+
+func setCondition(webSite *websitev1beta1.WebSite, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&webSite.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: webSite.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// configMapErrorReason returns ReasonConfigMapNotAllowed if the error is a violation of the ConfigMap policy, or the reason otherwise.
+func configMapErrorReason(err error, reason string) string {
+	if errors.Is(err, errConfigMapNotAllowed) {
+		return ReasonConfigMapNotAllowed
+	}
+	return reason
+}
+
+func setReadyCondition(webSite *websitev1beta1.WebSite) {
+	for _, condType := range stageConditions {
+		cond := meta.FindStatusCondition(webSite.Status.Conditions, condType)
+		if cond == nil {
+			setCondition(webSite, websitev1beta1.ConditionReady, metav1.ConditionFalse, ReasonConditionMissing, condType+" is not reported yet")
+			return
+		}
+		if cond.Status != metav1.ConditionTrue {
+			setCondition(webSite, websitev1beta1.ConditionReady, metav1.ConditionFalse, cond.Reason, condType+": "+cond.Message)
+			return
+		}
+	}
+	setCondition(webSite, websitev1beta1.ConditionReady, metav1.ConditionTrue, ReasonReady, "")
+}
+
+// isRevisionServed returns true if the build of the revision has succeeded and nginx serves it.
+func isRevisionServed(conditions []metav1.Condition) bool {
+	return meta.IsStatusConditionTrue(conditions, websitev1beta1.ConditionBuildSucceeded) &&
+		meta.IsStatusConditionTrue(conditions, websitev1beta1.ConditionNginxAvailable)
+}
+
+func (r *WebSiteReconciler) updateNginxConditions(ctx context.Context, webSite *websitev1beta1.WebSite, deploymentName, revision string) error {
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: deploymentName}, deployment)
+	if apierrors.IsNotFound(err) {
+		setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonUnavailable, "Deployment is not created yet")
+		if webSite.Spec.Artifacts == nil {
+			setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionUnknown, ReasonBuilding, "waiting for the build of revision "+revision)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	available := metav1.ConditionFalse
+	message := "Deployment does not have minimum availability"
+	for _, cond := range deployment.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			available = metav1.ConditionStatus(cond.Status)
+			message = cond.Message
+		}
+	}
+	if available == metav1.ConditionTrue {
+		setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionTrue, ReasonAvailable, message)
+	} else {
+		setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonUnavailable, message)
+	}
+
+	// the build by Job is reported in reconcileBuild
+	if webSite.Spec.Artifacts != nil {
+		return nil
+	}
+
+	if isRolloutCompleted(deployment) {
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionTrue, ReasonSucceeded, "revision "+revision+" has been built")
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	err = r.client.List(ctx, pods, client.InNamespace(webSite.Namespace), client.MatchingLabels{
+		ManagedByKey: OperatorName,
+		AppNameKey:   AppNameNginx,
+		InstanceKey:  webSite.Name,
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if !isCurrentBuildPod(&pod, deployment, revision) {
+			continue
+		}
+		if reason, msg := buildFailure(&pod); len(msg) != 0 {
+			setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, reason, msg)
+			return nil
+		}
+	}
+	setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionUnknown, ReasonBuilding, "waiting for the build of revision "+revision)
+	return nil
+}
+
+func isRolloutCompleted(deployment *appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == replicas &&
+		deployment.Status.AvailableReplicas == replicas
+}
+
+func isCurrentBuildPod(pod *corev1.Pod, deployment *appsv1.Deployment, revision string) bool {
+	if pod.Annotations[AnnChecksumConfig] != deployment.Spec.Template.Annotations[AnnChecksumConfig] {
+		return false
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name != BuildScriptName {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == "REVISION" {
+				return env.Value == revision
+			}
+		}
+	}
+	return false
+}
+
+// buildFailure returns the reason and the message of the failure of the build container in the pod.
+// The message is empty unless the build has failed.
+func buildFailure(pod *corev1.Pod) (string, string) {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != BuildScriptName {
+			continue
+		}
+		terminated := status.State.Terminated
+		if terminated == nil && status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+			terminated = status.LastTerminationState.Terminated
+		}
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		if timeout := buildTimeout(pod); len(timeout) != 0 && isTimeoutExit(terminated) {
+			return ReasonBuildTimedOut, fmt.Sprintf("build container in pod %s timed out after %ss", pod.Name, timeout)
+		}
+		return ReasonBuildFailed, fmt.Sprintf("build container in pod %s exited with code %d: %s", pod.Name, terminated.ExitCode, terminated.Reason)
+	}
+	return "", ""
+}
+
+// isTimeoutExit returns true if the container has been terminated by timeout(1).
+func isTimeoutExit(terminated *corev1.ContainerStateTerminated) bool {
+	if terminated.ExitCode == timeoutExitCode {
+		return true
+	}
+	// SIGKILL by the kernel is reported as OOMKilled
+	return terminated.ExitCode == timeoutKilledExitCode && terminated.Reason != "OOMKilled"
+}
+
+// buildTimeout returns the timeout of the build container in the pod in seconds, or empty if it has no timeout.
+func buildTimeout(pod *corev1.Pod) string {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name != BuildScriptName {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == "BUILD_TIMEOUT" {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+func (r *WebSiteReconciler) updateAfterBuildCondition(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	if webSite.Spec.AfterBuildScript == nil {
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionTrue, ReasonNotConfigured, "afterBuildScript is not specified")
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name}, job)
+	if apierrors.IsNotFound(err) {
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobRunning, "after-build Job has been created")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionTrue, ReasonCompleted, "")
+	case isJobTimedOut(job):
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobTimedOut, "after-build Job has exceeded activeDeadlineSeconds")
+	case isJobFailed(job):
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobFailed, "after-build Job has failed")
+	default:
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobRunning, "after-build Job is running")
+	}
+	return nil
+}
+
+func isJobFailed(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// isJobTimedOut returns true if the Job has failed for exceeding activeDeadlineSeconds.
+func isJobTimedOut(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue && cond.Reason == batchv1.JobReasonDeadlineExceeded {
+			return true
+		}
+	}
+	return false
+}
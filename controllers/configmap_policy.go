@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// errConfigMapNotAllowed is returned when a WebSite refers to a ConfigMap in a namespace that the policy does not allow.
+var errConfigMapNotAllowed = errors.New("ConfigMap is not allowed by the policy")
+
+// anyNamespace matches all namespaces in ConfigMapPolicy.
+const anyNamespace = "*"
+
+// ConfigMapPolicy declares the namespaces from which WebSites in each namespace may read ConfigMaps.
+// WebSites can always read ConfigMaps in their own namespace.
+// A nil policy allows WebSites to read ConfigMaps in any namespace.
+type ConfigMapPolicy struct {
+	// rules maps the namespace of WebSites to the namespaces of the ConfigMaps
+	rules map[string][]string
+}
+
+// ParseConfigMapPolicy parses the rules in the form of "TENANT=NAMESPACE[,NAMESPACE...]".
+// Both TENANT and NAMESPACE can be "*" to match all namespaces.
+// It returns nil if no rules are given.
+func ParseConfigMapPolicy(rules []string) (*ConfigMapPolicy, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	p := &ConfigMapPolicy{rules: make(map[string][]string)}
+	for _, rule := range rules {
+		tenant, namespaces, ok := strings.Cut(rule, "=")
+		tenant = strings.TrimSpace(tenant)
+		if !ok || len(tenant) == 0 {
+			return nil, fmt.Errorf("invalid ConfigMap policy %q: should be TENANT=NAMESPACE[,NAMESPACE...]", rule)
+		}
+		for _, ns := range strings.Split(namespaces, ",") {
+			ns = strings.TrimSpace(ns)
+			if len(ns) == 0 {
+				continue
+			}
+			p.rules[tenant] = append(p.rules[tenant], ns)
+		}
+	}
+	return p, nil
+}
+
+// Allows returns true if WebSites in the tenant namespace may read ConfigMaps in the namespace.
+func (p *ConfigMapPolicy) Allows(tenant, namespace string) bool {
+	if p == nil || tenant == namespace {
+		return true
+	}
+	for _, key := range []string{tenant, anyNamespace} {
+		allowed := p.rules[key]
+		if slices.Contains(allowed, namespace) || slices.Contains(allowed, anyNamespace) {
+			return true
+		}
+	}
+	return false
+}
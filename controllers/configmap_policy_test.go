@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigMapPolicy", func() {
+	It("should allow all namespaces without rules", func() {
+		p, err := ParseConfigMapPolicy(nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(p.Allows("tenant-a", "tenant-b")).Should(BeTrue())
+	})
+
+	It("should allow only the declared namespaces", func() {
+		p, err := ParseConfigMapPolicy([]string{
+			"*=website-operator-system",
+			"tenant-a=shared, templates",
+			"admin=*",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(p.Allows("tenant-a", "tenant-a")).Should(BeTrue())
+		Expect(p.Allows("tenant-a", "website-operator-system")).Should(BeTrue())
+		Expect(p.Allows("tenant-a", "templates")).Should(BeTrue())
+		Expect(p.Allows("tenant-a", "tenant-b")).Should(BeFalse())
+		Expect(p.Allows("tenant-b", "shared")).Should(BeFalse())
+		Expect(p.Allows("tenant-b", "website-operator-system")).Should(BeTrue())
+		Expect(p.Allows("admin", "tenant-b")).Should(BeTrue())
+	})
+
+	It("should reject malformed rules", func() {
+		_, err := ParseConfigMapPolicy([]string{"website-operator-system"})
+		Expect(err).Should(HaveOccurred())
+		_, err = ParseConfigMapPolicy([]string{"=shared"})
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the events recorded on WebSites
+const (
+	EventRevisionDetected     = "RevisionDetected"
+	EventBuildStarted         = "BuildStarted"
+	EventBuildSucceeded       = "BuildSucceeded"
+	EventBuildFailed          = "BuildFailed"
+	EventAfterBuildJobCreated = "AfterBuildJobCreated"
+	EventAfterBuildJobFailed  = "AfterBuildJobFailed"
+	EventConfigMapMissing     = "ConfigMapMissing"
+	EventConfigRenderFailed   = "ConfigRenderFailed"
+	EventExtraResourceFailed  = "ExtraResourceApplyFailed"
+	EventRolledOut            = "RolledOut"
+	EventRolledBack           = "RolledBack"
+)
+
+// recordConfigMapError records an event if the ConfigMap referred by the WebSite is missing.
+// It must be called before the condition is updated with the error, so that the event is recorded only once until the condition changes.
+func (r *WebSiteReconciler) recordConfigMapError(webSite *websitev1beta1.WebSite, condType string, err error) {
+	if !apierrors.IsNotFound(err) {
+		return
+	}
+	prev := meta.FindStatusCondition(webSite.Status.Conditions, condType)
+	if prev != nil && prev.Status == metav1.ConditionFalse && prev.Message == err.Error() {
+		return
+	}
+	r.recorder.Event(webSite, corev1.EventTypeWarning, EventConfigMapMissing, err.Error())
+}
+
+// recordConditionEvents records events for the conditions that have changed since before.
+// Failures are recorded only once until the condition changes, so that retries do not flood the events.
+func (r *WebSiteReconciler) recordConditionEvents(webSite *websitev1beta1.WebSite, before []metav1.Condition) {
+	for _, cond := range webSite.Status.Conditions {
+		prev := meta.FindStatusCondition(before, cond.Type)
+		if prev != nil && prev.Status == cond.Status && prev.Reason == cond.Reason {
+			// the message of these conditions contains the revision
+			if cond.Type != websitev1beta1.ConditionRevisionResolved && cond.Type != websitev1beta1.ConditionBuildSucceeded {
+				continue
+			}
+			if prev.Message == cond.Message {
+				continue
+			}
+		}
+
+		eventType, reason := conditionEvent(&cond)
+		if len(reason) == 0 {
+			continue
+		}
+		r.recorder.Event(webSite, eventType, reason, cond.Message)
+	}
+}
+
+// conditionEvent returns the type and the reason of the event for the condition, or an empty reason if no event should be recorded.
+func conditionEvent(cond *metav1.Condition) (string, string) {
+	switch cond.Type {
+	case websitev1beta1.ConditionRevisionResolved:
+		if cond.Status == metav1.ConditionTrue {
+			return corev1.EventTypeNormal, EventRevisionDetected
+		}
+	case websitev1beta1.ConditionConfigRendered:
+		if cond.Status == metav1.ConditionFalse {
+			return corev1.EventTypeWarning, EventConfigRenderFailed
+		}
+	case websitev1beta1.ConditionBuildSucceeded:
+		switch cond.Status {
+		case metav1.ConditionUnknown:
+			return corev1.EventTypeNormal, EventBuildStarted
+		case metav1.ConditionTrue:
+			return corev1.EventTypeNormal, EventBuildSucceeded
+		case metav1.ConditionFalse:
+			return corev1.EventTypeWarning, EventBuildFailed
+		}
+	case websitev1beta1.ConditionAfterBuildCompleted:
+		switch cond.Reason {
+		case ReasonJobRunning:
+			return corev1.EventTypeNormal, EventAfterBuildJobCreated
+		case ReasonJobFailed, ReasonJobTimedOut, ReasonJobError:
+			return corev1.EventTypeWarning, EventAfterBuildJobFailed
+		}
+	case websitev1beta1.ConditionExtraResourcesApplied:
+		if cond.Status == metav1.ConditionFalse {
+			return corev1.EventTypeWarning, EventExtraResourceFailed
+		}
+	case websitev1beta1.ConditionRolloutSucceeded:
+		switch cond.Status {
+		case metav1.ConditionTrue:
+			return corev1.EventTypeNormal, EventRolledOut
+		case metav1.ConditionFalse:
+			return corev1.EventTypeWarning, EventRolledBack
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AnnRetain keeps the extra resource when it is removed from spec.extraResources if the value is "true".
+	// The retained resource is released from the WebSite, so it is not deleted with the WebSite either.
+	AnnRetain = "website.zoetrope.github.io/retain"
+)
+
+// operatorKinds are the kinds of the resources that website-operator creates by itself for WebSites.
+// They are never taken over as extra resources, not to delete the ones website-operator needs.
+var operatorKinds = []schema.GroupKind{
+	{Group: "", Kind: "ConfigMap"},
+	{Group: "", Kind: "Secret"},
+	{Group: "", Kind: "Service"},
+	{Group: "", Kind: "PersistentVolumeClaim"},
+	{Group: "apps", Kind: "Deployment"},
+	{Group: "batch", Kind: "Job"},
+	{Group: "networking.k8s.io", Kind: "Ingress"},
+	{Group: "gateway.networking.k8s.io", Kind: "HTTPRoute"},
+	{Group: websitev1beta1.GroupVersion.Group, Kind: "WebSite"},
+}
+
+func extraResourceRef(obj *unstructured.Unstructured) websitev1beta1.ExtraResourceRef {
+	return websitev1beta1.ExtraResourceRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Name:       obj.GetName(),
+	}
+}
+
+// sameExtraResource returns true if the references refer to the same resource regardless of the versions.
+func sameExtraResource(a, b websitev1beta1.ExtraResourceRef) bool {
+	gvA, errA := schema.ParseGroupVersion(a.APIVersion)
+	gvB, errB := schema.ParseGroupVersion(b.APIVersion)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return gvA.Group == gvB.Group && a.Kind == b.Kind && a.Name == b.Name
+}
+
+// pruneExtraResources deletes the extra resources in the inventory that are no longer rendered, and records the applied ones as the inventory.
+// The resources that fail to be pruned are kept in the inventory to retry.
+func (r *WebSiteReconciler) pruneExtraResources(ctx context.Context, webSite *websitev1beta1.WebSite, applied []websitev1beta1.ExtraResourceRef) error {
+	refs := webSite.Status.ExtraResources
+	if needsExtraResourcesSeed(webSite) {
+		seed, err := r.seedExtraResources(ctx, webSite)
+		if err != nil {
+			return err
+		}
+		refs = seed
+	}
+
+	inventory := applied
+	var errs []error
+	for _, ref := range refs {
+		// the same resource may be referred to by another version
+		if slices.ContainsFunc(applied, func(a websitev1beta1.ExtraResourceRef) bool { return sameExtraResource(a, ref) }) {
+			continue
+		}
+		err := r.pruneExtraResource(ctx, webSite, ref)
+		if err != nil {
+			inventory = append(inventory, ref)
+			errs = append(errs, err)
+		}
+	}
+	webSite.Status.ExtraResources = inventory
+	return errors.Join(errs...)
+}
+
+func (r *WebSiteReconciler) pruneExtraResource(ctx context.Context, webSite *websitev1beta1.WebSite, ref websitev1beta1.ExtraResourceRef) error {
+	log := r.log.WithValues("website", webSite.Name, "kind", ref.Kind, "name", ref.Name)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(ref.APIVersion)
+	obj.SetKind(ref.Kind)
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: ref.Name}, obj)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, webSite) {
+		return nil
+	}
+
+	if obj.GetAnnotations()[AnnRetain] == "true" {
+		obj.SetOwnerReferences(slices.DeleteFunc(obj.GetOwnerReferences(), func(owner metav1.OwnerReference) bool {
+			return owner.UID == webSite.UID
+		}))
+		err = r.client.Update(ctx, obj)
+		if err != nil {
+			return err
+		}
+		log.Info("release extraResource successfully")
+		return nil
+	}
+
+	err = r.client.Delete(ctx, obj)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	log.Info("prune extraResource successfully")
+	return nil
+}
+
+// needsExtraResourcesSeed returns true if the WebSite was served by website-operator that did not record the inventory.
+// Such WebSites have the revision but not ExtraResourcesApplied condition.
+func needsExtraResourcesSeed(webSite *websitev1beta1.WebSite) bool {
+	return len(webSite.Status.Revision) != 0 && len(webSite.Status.ExtraResources) == 0 &&
+		meta.FindStatusCondition(webSite.Status.Conditions, websitev1beta1.ConditionExtraResourcesApplied) == nil
+}
+
+// seedExtraResources returns the resources controlled by the WebSite in its namespace as the inventory of the extra resources,
+// so that the ones applied before the inventory was recorded are also pruned.
+// The kinds that website-operator cannot list are skipped, as it cannot have applied them either.
+func (r *WebSiteReconciler) seedExtraResources(ctx context.Context, webSite *websitev1beta1.WebSite) ([]websitev1beta1.ExtraResourceRef, error) {
+	if r.discovery == nil {
+		return nil, nil
+	}
+	log := r.log.WithValues("website", webSite.Name)
+
+	lists, err := r.discovery.ServerPreferredNamespacedResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, lists)
+
+	var refs []websitev1beta1.ExtraResourceRef
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			if strings.Contains(res.Name, "/") || slices.Contains(operatorKinds, gv.WithKind(res.Kind).GroupKind()) {
+				continue
+			}
+			objs := &metav1.PartialObjectMetadataList{}
+			objs.SetGroupVersionKind(gv.WithKind(res.Kind + "List"))
+			err := r.apiReader.List(ctx, objs, client.InNamespace(webSite.Namespace))
+			if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for i := range objs.Items {
+				if metav1.IsControlledBy(&objs.Items[i], webSite) {
+					refs = append(refs, websitev1beta1.ExtraResourceRef{
+						APIVersion: gv.String(),
+						Kind:       res.Kind,
+						Name:       objs.Items[i].Name,
+					})
+				}
+			}
+		}
+	}
+	log.Info("seed the inventory of extraResources", "count", len(refs))
+	return refs, nil
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	AppNameGitAuth = "git-auth"
+	GitAuthSuffix  = "-git-auth"
+	// GitAuthPath is the directory where the credentials for HTTPS are mounted.
+	GitAuthPath = "/etc/git-auth"
+	// GitHubAppPrivateKey is the key of the private key in the secret of the GitHub App.
+	GitHubAppPrivateKey = "privateKey"
+	// AnnTokenExpiresAt is the annotation of the secret that has the expiration of the installation token.
+	AnnTokenExpiresAt = "website.zoetrope.github.io/token-expires-at"
+	// AnnGitHubApp is the annotation of the secret that has the GitHub App the installation token is minted for.
+	AnnGitHubApp = "website.zoetrope.github.io/github-app"
+
+	// defaultGitHubAPIURL is the URL of the GitHub API used if the WebSite does not specify it.
+	defaultGitHubAPIURL = "https://api.github.com"
+	// gitHubTokenUsername is the username to use the installation tokens of GitHub Apps.
+	gitHubTokenUsername = "x-access-token"
+	// gitHubTokenRefreshBefore is the duration before the expiration to refresh the installation token.
+	// The installation tokens expire in an hour.
+	gitHubTokenRefreshBefore = 30 * time.Minute
+	// gitHubTokenCheckInterval is the interval to check the expiration of the installation token.
+	gitHubTokenCheckInterval = 10 * time.Minute
+)
+
+// gitCredentialHelper is the credential helper of git that reads the credentials mounted at GitAuthPath.
+// The files are read every time, so that the refreshed tokens are used.
+const gitCredentialHelper = `!f() { test "$1" = get || exit 0; ` +
+	`if [ -f ` + GitAuthPath + `/username ]; then echo "username=$(cat ` + GitAuthPath + `/username)"; else echo username=git; fi; ` +
+	`echo "password=$(cat ` + GitAuthPath + `/password)"; }; f`
+
+// errGitHubAPIURLNotAllowed is returned when a WebSite specifies the URL of the GitHub API that the operator does not allow.
+var errGitHubAPIURLNotAllowed = errors.New("GitHub API URL is not allowed")
+
+func usesGitHubApp(webSite *websitev1beta1.WebSite) bool {
+	return webSite.Spec.GitAuth != nil && webSite.Spec.GitAuth.GitHubApp != nil
+}
+
+// gitAuthSecretName returns the name of the secret that has the credentials for HTTPS.
+// The secret for GitHub Apps is managed by the operator.
+func gitAuthSecretName(webSite *websitev1beta1.WebSite) string {
+	if usesGitHubApp(webSite) {
+		return webSite.Name + GitAuthSuffix
+	}
+	return *webSite.Spec.GitAuth.SecretName
+}
+
+func makeGitAuthVolume(webSite *websitev1beta1.WebSite) corev1.Volume {
+	return corev1.Volume{
+		Name: "git-auth",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  gitAuthSecretName(webSite),
+				DefaultMode: ptr.To[int32](0440),
+			},
+		},
+	}
+}
+
+func makeGitAuthVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		MountPath: GitAuthPath,
+		Name:      "git-auth",
+		ReadOnly:  true,
+	}
+}
+
+// makeGitAuthEnv returns the environment variables that configure git to use the credentials at GitAuthPath.
+func makeGitAuthEnv() []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name:  "GIT_CONFIG_COUNT",
+			Value: "1",
+		},
+		{
+			Name:  "GIT_CONFIG_KEY_0",
+			Value: "credential.helper",
+		},
+		{
+			Name:  "GIT_CONFIG_VALUE_0",
+			Value: gitCredentialHelper,
+		},
+	}
+}
+
+// reconcileGitAuth keeps the installation token of the GitHub App in the secret, and deletes it if it is no longer used.
+func (r *WebSiteReconciler) reconcileGitAuth(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	if !usesGitHubApp(webSite) {
+		return r.deleteOwnedObject(ctx, webSite, webSite.Name+GitAuthSuffix, &corev1.Secret{})
+	}
+	log := r.log.WithValues("website", webSite.Name)
+	app := webSite.Spec.GitAuth.GitHubApp
+	appKey := fmt.Sprintf("%d/%d", app.AppID, app.InstallationID)
+
+	secret := &corev1.Secret{}
+	err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: webSite.Name + GitAuthSuffix}, secret)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && secret.Annotations[AnnGitHubApp] == appKey {
+		expiresAt, err := time.Parse(time.RFC3339, secret.Annotations[AnnTokenExpiresAt])
+		if err == nil && time.Until(expiresAt) > gitHubTokenRefreshBefore {
+			return nil
+		}
+	}
+
+	apiURL := app.APIURL
+	if len(apiURL) == 0 {
+		apiURL = defaultGitHubAPIURL
+	}
+	// the installation token and the JWT signed by the private key are sent to the URL
+	if !slices.Contains(r.gitHubAPIURLs, strings.TrimSuffix(apiURL, "/")) {
+		return fmt.Errorf("%w: %s", errGitHubAPIURLNotAllowed, apiURL)
+	}
+
+	keySecret := &corev1.Secret{}
+	err = r.apiReader.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: app.PrivateKeySecretName}, keySecret)
+	if err != nil {
+		return err
+	}
+	privateKey, ok := keySecret.Data[GitHubAppPrivateKey]
+	if !ok {
+		return fmt.Errorf("secret %s does not have %s", app.PrivateKeySecretName, GitHubAppPrivateKey)
+	}
+	token, err := mintGitHubAppToken(ctx, apiURL, app.AppID, app.InstallationID, privateKey)
+	if err != nil {
+		return err
+	}
+
+	secret = &corev1.Secret{}
+	secret.SetNamespace(webSite.Namespace)
+	secret.SetName(webSite.Name + GitAuthSuffix)
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, secret, func() error {
+		setStandardLabels(AppNameGitAuth, &secret.ObjectMeta)
+		secret.Labels[InstanceKey] = webSite.Name
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[AnnGitHubApp] = appKey
+		secret.Annotations[AnnTokenExpiresAt] = token.ExpiresAt.Format(time.RFC3339)
+		secret.Data = map[string][]byte{
+			"username": []byte(gitHubTokenUsername),
+			"password": []byte(token.Token),
+		}
+		return ctrl.SetControllerReference(webSite, secret, r.scheme)
+	})
+	if err != nil {
+		log.Error(err, "unable to create-or-update Secret for GitHub App")
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile Secret for GitHub App successfully", "op", op, "expiresAt", token.ExpiresAt)
+	}
+	return nil
+}
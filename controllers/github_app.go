@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/well"
+)
+
+// gitHubAppToken is an installation access token of a GitHub App.
+type gitHubAppToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// mintGitHubAppToken creates an installation access token of the GitHub App.
+// See https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-an-installation-access-token-for-a-github-app
+func mintGitHubAppToken(ctx context.Context, apiURL string, appID, installationID int64, privateKey []byte) (*gitHubAppToken, error) {
+	jwt, err := gitHubAppJWT(appID, privateKey, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(apiURL, "/"), installationID),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	cli := &well.HTTPClient{Client: &http.Client{Timeout: 30 * time.Second}}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create an installation token: %s", resp.Status)
+	}
+
+	token := &gitHubAppToken{}
+	err = json.NewDecoder(resp.Body).Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Token) == 0 {
+		return nil, errors.New("installation token is empty")
+	}
+	return token, nil
+}
+
+// gitHubAppJWT returns the JSON Web Token signed by the private key of the GitHub App.
+func gitHubAppJWT(appID int64, privateKey []byte, now time.Time) (string, error) {
+	key, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// allow the clock drift of GitHub
+		"iat": now.Add(-60 * time.Second).Unix(),
+		// GitHub accepts the tokens that expire in 10 minutes at most
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// parseRSAPrivateKey parses the private key in PKCS #1 or PKCS #8 PEM, which GitHub generates in PKCS #1.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
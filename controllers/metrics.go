@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "website_operator"
+
+var (
+	revisionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "website_revision_info",
+		Help:      "The revision served by the WebSite. The value is always 1.",
+	}, []string{"namespace", "website", "revision"})
+
+	revisionDeploySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "website_revision_deploy_seconds",
+		Help:      "The time from the detection of a revision to serving it.",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 10),
+	}, []string{"namespace", "website"})
+
+	buildDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "website_build_duration_seconds",
+		Help:      "The duration of the builds and the after-build Jobs.",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 10),
+	}, []string{"namespace", "website", "stage", "result"})
+
+	buildsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "website_builds_total",
+		Help:      "The number of the finished builds and after-build Jobs.",
+	}, []string{"namespace", "website", "stage", "result"})
+
+	revisionPollErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "revision_watcher_poll_errors_total",
+		Help:      "The number of errors of the revision watcher while polling repo-checker.",
+	}, []string{"namespace", "website"})
+
+	repoCheckerRequestSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "revision_watcher_request_duration_seconds",
+		Help:      "The duration of the requests from the revision watcher to repo-checker.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 3, 5, 10},
+	}, []string{"namespace", "website"})
+)
+
+// stages of the builds in the metrics
+const (
+	stageBuild      = "build"
+	stageAfterBuild = "after-build"
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		revisionInfo,
+		revisionDeploySeconds,
+		buildDurationSeconds,
+		buildsTotal,
+		revisionPollErrorsTotal,
+		repoCheckerRequestSeconds,
+	)
+}
+
+// revisionDetection remembers when each revision has been detected to measure the time to serve it.
+type revisionDetection struct {
+	mu       sync.Mutex
+	detected map[types.NamespacedName]detectedRevision
+}
+
+type detectedRevision struct {
+	revision string
+	at       time.Time
+}
+
+func (d *revisionDetection) detect(name types.NamespacedName, revision string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.detected == nil {
+		d.detected = make(map[types.NamespacedName]detectedRevision)
+	}
+	if d.detected[name].revision == revision {
+		return
+	}
+	d.detected[name] = detectedRevision{revision: revision, at: time.Now()}
+}
+
+// served returns the time since the revision has been detected.
+func (d *revisionDetection) served(name types.NamespacedName, revision string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	det, ok := d.detected[name]
+	if !ok || det.revision != revision {
+		return 0, false
+	}
+	delete(d.detected, name)
+	return time.Since(det.at), true
+}
+
+func (d *revisionDetection) forget(name types.NamespacedName) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.detected, name)
+}
+
+// updateRevisionMetrics updates the metrics of the served revision.
+// The Deployment is updated as soon as the revision is detected, so the time to serve it is observed when the build succeeds and nginx becomes available.
+func (r *WebSiteReconciler) updateRevisionMetrics(webSite *websitev1beta1.WebSite, oldRevision string, before []metav1.Condition) {
+	revision := webSite.Status.Revision
+	if len(revision) == 0 {
+		return
+	}
+	revisionInfo.WithLabelValues(webSite.Namespace, webSite.Name, revision).Set(1)
+	if len(oldRevision) != 0 && oldRevision != revision {
+		revisionInfo.DeleteLabelValues(webSite.Namespace, webSite.Name, oldRevision)
+	}
+	if isRevisionServed(before) || !isRevisionServed(webSite.Status.Conditions) {
+		return
+	}
+	if d, ok := r.detection.served(types.NamespacedName{Namespace: webSite.Namespace, Name: webSite.Name}, revision); ok {
+		revisionDeploySeconds.WithLabelValues(webSite.Namespace, webSite.Name).Observe(d.Seconds())
+	}
+}
+
+// observeBuildMetrics observes the builds finished since before.
+// The duration is measured from the time when the condition became in progress.
+func observeBuildMetrics(webSite *websitev1beta1.WebSite, before []metav1.Condition) {
+	observe := func(condType, stage string, inProgress func(*metav1.Condition) bool) {
+		prev := meta.FindStatusCondition(before, condType)
+		cur := meta.FindStatusCondition(webSite.Status.Conditions, condType)
+		if prev == nil || cur == nil || !inProgress(prev) || inProgress(cur) {
+			return
+		}
+		result := ""
+		switch {
+		case cur.Status == metav1.ConditionTrue:
+			result = "succeeded"
+		case cur.Reason == ReasonBuildFailed || cur.Reason == ReasonJobFailed:
+			result = "failed"
+		case cur.Reason == ReasonBuildTimedOut || cur.Reason == ReasonJobTimedOut:
+			result = "timeout"
+		default:
+			return
+		}
+		buildsTotal.WithLabelValues(webSite.Namespace, webSite.Name, stage, result).Inc()
+		buildDurationSeconds.WithLabelValues(webSite.Namespace, webSite.Name, stage, result).Observe(time.Since(prev.LastTransitionTime.Time).Seconds())
+	}
+
+	observe(websitev1beta1.ConditionBuildSucceeded, stageBuild, func(c *metav1.Condition) bool {
+		return c.Status == metav1.ConditionUnknown && c.Reason == ReasonBuilding
+	})
+	observe(websitev1beta1.ConditionAfterBuildCompleted, stageAfterBuild, func(c *metav1.Condition) bool {
+		return c.Reason == ReasonJobRunning
+	})
+}
+
+// deleteWebSiteMetrics deletes the metrics of the deleted WebSite.
+func (r *WebSiteReconciler) deleteWebSiteMetrics(name types.NamespacedName) {
+	labels := prometheus.Labels{"namespace": name.Namespace, "website": name.Name}
+	revisionInfo.DeletePartialMatch(labels)
+	revisionDeploySeconds.DeletePartialMatch(labels)
+	buildDurationSeconds.DeletePartialMatch(labels)
+	buildsTotal.DeletePartialMatch(labels)
+	revisionPollErrorsTotal.DeletePartialMatch(labels)
+	repoCheckerRequestSeconds.DeletePartialMatch(labels)
+	r.detection.forget(name)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// NotifyPathPrefix is the path prefix of the endpoint that repo-checker notifies the update of the revision.
+// The full path is /notify/<namespace>/<name>.
+const NotifyPathPrefix = "/notify/"
+
+// AnnNotifiedAt is the annotation key of WebSites that records the time of the last notification received by a replica other than the leader.
+// Updating it triggers the reconciliation by the leader.
+const AnnNotifiedAt = "website.zoetrope.github.io/notified-at"
+
+func newNotificationServer(client client.Client, apiReader client.Reader, log logr.Logger, ch chan<- event.TypedGenericEvent[*websitev1beta1.WebSite], elected <-chan struct{}, addr string) manager.Runnable {
+	return &notificationServer{
+		client:    client,
+		apiReader: apiReader,
+		log:       log,
+		channel:   ch,
+		elected:   elected,
+		addr:      addr,
+	}
+}
+
+// notificationServer receives notifications from repo-checker and triggers the reconciliation of the WebSite.
+// The request only triggers the reconciliation, and the revision is always obtained from repo-checker.
+// The notification is signed by the webhook secret of the WebSite.
+// It runs on all the replicas of the operator because the Service of the notification selects all of them.
+type notificationServer struct {
+	client    client.Client
+	apiReader client.Reader
+	log       logr.Logger
+	channel   chan<- event.TypedGenericEvent[*websitev1beta1.WebSite]
+	// elected is closed when the replica becomes the leader
+	elected <-chan struct{}
+	addr    string
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable
+func (s notificationServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements Runnable.Start
+func (s notificationServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+NotifyPathPrefix+"{namespace}/{name}", s.handleNotify)
+	serv := &http.Server{
+		Addr:              s.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = serv.Shutdown(shutdownCtx)
+	}()
+
+	err := serv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s notificationServer) handleNotify(w http.ResponseWriter, r *http.Request) {
+	site := &websitev1beta1.WebSite{}
+	err := s.client.Get(r.Context(), client.ObjectKey{Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}, site)
+	if apierrors.IsNotFound(err) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Error(err, "failed to get WebSite")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := s.authenticate(r, site)
+	if err != nil {
+		s.log.Error(err, "failed to authenticate notification", "namespace", site.Namespace, "name", site.Name)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.log.Info("revision update notified", "namespace", site.Namespace, "name", site.Name)
+	select {
+	case <-s.elected:
+	default:
+		// the controller runs only on the leader, so ask it to reconcile the WebSite by updating the annotation
+		patch := client.MergeFrom(site.DeepCopy())
+		if site.Annotations == nil {
+			site.Annotations = make(map[string]string)
+		}
+		site.Annotations[AnnNotifiedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+		err := s.client.Patch(r.Context(), site, patch)
+		if err != nil {
+			s.log.Error(err, "failed to patch WebSite")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	ev := event.TypedGenericEvent[*websitev1beta1.WebSite]{
+		Object: site,
+	}
+	select {
+	case s.channel <- ev:
+	case <-r.Context().Done():
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authenticate verifies the signature of the notification by the webhook secret of the WebSite, and returns the status code on failure.
+func (s notificationServer) authenticate(r *http.Request, site *websitev1beta1.WebSite) (int, error) {
+	ref := site.Spec.WebhookSecret
+	if ref == nil {
+		// repo-checker notifies the operator only if the webhook secret is specified
+		return http.StatusForbidden, errors.New("notification is not enabled")
+	}
+	secret := &corev1.Secret{}
+	err := s.apiReader.Get(r.Context(), client.ObjectKey{Namespace: site.Namespace, Name: ref.Name}, secret)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	err = checker.VerifyNotification(r, secret.Data[ref.Key], time.Now())
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Notification server", func() {
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		secret := &corev1.Secret{}
+		secret.Namespace = "test"
+		secret.Name = "notifysecret"
+		secret.Data = map[string][]byte{"token": []byte("secret")}
+		err := k8sClient.Create(ctx, secret)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, secret))).To(Succeed())
+		})
+
+		for _, name := range []string{"notifysite", "nowebhook"} {
+			site := newWebSite().withRawBuildScript().build()
+			site.Name = name
+			if name == "notifysite" {
+				site.Spec.WebhookSecret = &websitev1beta1.SecretKey{Name: "notifysecret", Key: "token"}
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, site))).To(Succeed())
+			})
+		}
+	})
+
+	notify := func(s *notificationServer, name string, secret []byte) int {
+		req := httptest.NewRequest(http.MethodPost, NotifyPathPrefix+"test/"+name, nil)
+		req.SetPathValue("namespace", "test")
+		req.SetPathValue("name", name)
+		if secret != nil {
+			checker.SignNotification(req, secret, time.Now())
+		}
+		rec := httptest.NewRecorder()
+		s.handleNotify(rec, req)
+		return rec.Code
+	}
+
+	It("should reject the notifications without the valid signature", func() {
+		ch := make(chan event.TypedGenericEvent[*websitev1beta1.WebSite], 1)
+		elected := make(chan struct{})
+		close(elected)
+		s := newNotificationServer(k8sClient, k8sClient, ctrl.Log.WithName("NotificationServer"), ch, elected, "").(*notificationServer)
+
+		Expect(notify(s, "notifysite", nil)).Should(Equal(http.StatusUnauthorized))
+		Expect(notify(s, "notifysite", []byte("wrong"))).Should(Equal(http.StatusUnauthorized))
+		Expect(notify(s, "nowebhook", []byte("secret"))).Should(Equal(http.StatusForbidden))
+		Expect(notify(s, "missing", []byte("secret"))).Should(Equal(http.StatusNotFound))
+		Expect(ch).ShouldNot(Receive())
+	})
+
+	It("should trigger the reconciliation on the leader", func() {
+		ch := make(chan event.TypedGenericEvent[*websitev1beta1.WebSite], 1)
+		elected := make(chan struct{})
+		close(elected)
+		s := newNotificationServer(k8sClient, k8sClient, ctrl.Log.WithName("NotificationServer"), ch, elected, "").(*notificationServer)
+
+		Expect(notify(s, "notifysite", []byte("secret"))).Should(Equal(http.StatusAccepted))
+		var ev event.TypedGenericEvent[*websitev1beta1.WebSite]
+		Expect(ch).Should(Receive(&ev))
+		Expect(ev.Object.Name).Should(Equal("notifysite"))
+	})
+
+	It("should ask the leader to reconcile on the other replicas", func() {
+		ch := make(chan event.TypedGenericEvent[*websitev1beta1.WebSite], 1)
+		s := newNotificationServer(k8sClient, k8sClient, ctrl.Log.WithName("NotificationServer"), ch, make(chan struct{}), "").(*notificationServer)
+
+		Expect(notify(s, "notifysite", []byte("secret"))).Should(Equal(http.StatusAccepted))
+		Expect(ch).ShouldNot(Receive())
+
+		site := &websitev1beta1.WebSite{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "notifysite"}, site)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(site.Annotations).Should(HaveKey(AnnNotifiedAt))
+	})
+})
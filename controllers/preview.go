@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// LabelPreviewOf is the label key of preview WebSites. Its value is the name of the parent WebSite.
+	LabelPreviewOf = "website.zoetrope.github.io/preview-of"
+	// AnnPreviewRef is the annotation key of preview WebSites. Its value is the ref of the pull request such as refs/pull/1/head.
+	AnnPreviewRef  = "website.zoetrope.github.io/preview-ref"
+	AppNamePreview = "preview"
+	PreviewSuffix  = "-pr-"
+)
+
+// defaultPreviewMaxAge is the duration to keep a preview after the last commit of its pull request if MaxAge is omitted.
+const defaultPreviewMaxAge = 14 * 24 * time.Hour
+
+type previewHostParams struct {
+	Number            int
+	ResourceName      string
+	ResourceNamespace string
+}
+
+func previewName(webSite *websitev1beta1.WebSite, number int) string {
+	return fmt.Sprintf("%s%s%d", webSite.Name, PreviewSuffix, number)
+}
+
+func isPreview(webSite *websitev1beta1.WebSite) bool {
+	_, ok := webSite.Labels[LabelPreviewOf]
+	return ok
+}
+
+// activePullRequests returns the newest pull requests up to the limit, excluding the ones whose last commit is older than MaxAge.
+// The refs of closed pull requests remain on GitHub and Gitea, so they are excluded by the age.
+func activePullRequests(previews *websitev1beta1.PreviewsSpec, pulls []checker.PullRequest, now time.Time) []checker.PullRequest {
+	maxAge := defaultPreviewMaxAge
+	if previews.MaxAge != nil {
+		maxAge = previews.MaxAge.Duration
+	}
+	var active []checker.PullRequest
+	for _, pr := range pulls {
+		if len(active) >= int(previews.Limit) {
+			break
+		}
+		if pr.Timestamp != nil && now.Sub(*pr.Timestamp) > maxAge {
+			continue
+		}
+		active = append(active, pr)
+	}
+	return active
+}
+
+// makePreviewEnv returns the environment variable that has the ref of the pull request for the build of a preview.
+// The commits of pull requests from forks are fetched only by the ref.
+func makePreviewEnv(webSite *websitev1beta1.WebSite) []corev1.EnvVar {
+	ref, ok := webSite.Annotations[AnnPreviewRef]
+	if !ok {
+		return nil
+	}
+	return []corev1.EnvVar{
+		{
+			Name:  "PR_REF",
+			Value: ref,
+		},
+	}
+}
+
+// reconcilePreviews creates a preview WebSite for each of the newest pull requests, and deletes the others.
+// The previews are made from the stored spec of the WebSite, so that they follow its BuildTemplate too.
+func (r *WebSiteReconciler) reconcilePreviews(ctx context.Context, webSite *websitev1beta1.WebSite, stored *websitev1beta1.WebSiteSpec) error {
+	log := r.log.WithValues("website", webSite.Name)
+
+	var pulls []checker.PullRequest
+	var hostTmpl *template.Template
+	if webSite.Spec.Previews != nil {
+		var err error
+		pulls, err = r.revisionClient.GetPullRequests(ctx, webSite)
+		if err != nil {
+			return err
+		}
+		pulls = activePullRequests(webSite.Spec.Previews, pulls, time.Now())
+		hostTmpl, err = template.New("host").Parse(webSite.Spec.Previews.Host)
+		if err != nil {
+			return err
+		}
+	}
+
+	var statuses []websitev1beta1.PreviewStatus
+	desired := make(map[string]bool)
+	for _, pr := range pulls {
+		buf := new(bytes.Buffer)
+		err := hostTmpl.Execute(buf, previewHostParams{
+			Number:            pr.Number,
+			ResourceName:      webSite.Name,
+			ResourceNamespace: webSite.Namespace,
+		})
+		if err != nil {
+			return err
+		}
+		publicURL := previewURL(webSite.Spec.PublicURL, buf.String())
+
+		preview := &websitev1beta1.WebSite{}
+		preview.SetNamespace(webSite.Namespace)
+		preview.SetName(previewName(webSite, pr.Number))
+		op, err := controllerutil.CreateOrUpdate(ctx, r.client, preview, func() error {
+			setStandardLabels(AppNamePreview, &preview.ObjectMeta)
+			preview.Labels[LabelPreviewOf] = webSite.Name
+			if preview.Annotations == nil {
+				preview.Annotations = make(map[string]string)
+			}
+			preview.Annotations[AnnPreviewRef] = pr.Ref
+			preview.Spec = *makePreviewSpec(stored, pr, publicURL)
+			return controllerutil.SetControllerReference(webSite, preview, r.scheme)
+		})
+		if err != nil {
+			log.Error(err, "unable to create-or-update preview", "number", pr.Number)
+			return err
+		}
+		if op != controllerutil.OperationResultNone {
+			log.Info("reconcile preview successfully", "op", op, "number", pr.Number)
+		}
+
+		desired[preview.Name] = true
+		statuses = append(statuses, websitev1beta1.PreviewStatus{
+			Number:   pr.Number,
+			Name:     preview.Name,
+			Revision: pr.Revision,
+			URL:      publicURL,
+		})
+	}
+
+	previews := &websitev1beta1.WebSiteList{}
+	err := r.client.List(ctx, previews, client.InNamespace(webSite.Namespace), client.MatchingLabels{LabelPreviewOf: webSite.Name})
+	if err != nil {
+		return err
+	}
+	for i := range previews.Items {
+		preview := &previews.Items[i]
+		if desired[preview.Name] {
+			continue
+		}
+		err := r.client.Delete(ctx, preview)
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete preview", "name", preview.Name)
+			return err
+		}
+		log.Info("delete preview successfully", "name", preview.Name)
+	}
+
+	webSite.Status.Previews = statuses
+	return nil
+}
+
+func makePreviewSpec(parent *websitev1beta1.WebSiteSpec, pr checker.PullRequest, publicURL string) *websitev1beta1.WebSiteSpec {
+	spec := parent.DeepCopy()
+	spec.Revision = pr.Revision
+	spec.Tag = ""
+	spec.Replicas = 1
+	spec.PublicURL = publicURL
+	spec.WebhookSecret = nil
+	if !parent.Previews.InheritSecrets {
+		// the build of a preview runs the code of the pull request, which may come from anyone
+		spec.BuildSecrets = nil
+		spec.DeployKeySecretName = nil
+		spec.GitAuth = nil
+	}
+	spec.Previews = nil
+	// previews are short-lived, so they do not have their own build caches
+	spec.BuildCache = nil
+	// the extra resources and the after-build script may have the side effects of the parent such as deployment and notification
+	spec.ExtraResources = nil
+	spec.AfterBuildScript = nil
+	return spec
+}
+
+// previewURL returns the URL of a preview that uses the same scheme as the parent.
+func previewURL(parentURL, host string) string {
+	scheme := "https"
+	if u, err := url.Parse(parentURL); err == nil && len(u.Scheme) != 0 {
+		scheme = u.Scheme
+	}
+	return scheme + "://" + host + "/"
+}
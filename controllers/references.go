@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"slices"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// configMapKey returns the key of the ConfigMap referenced by the source.
+// The ConfigMap is looked up in the namespace of the operator if the namespace is omitted.
+func (r *WebSiteReconciler) configMapKey(source *websitev1beta1.ConfigMapSource) client.ObjectKey {
+	ns := r.operatorNamespace
+	if len(source.Namespace) != 0 {
+		ns = source.Namespace
+	}
+	return client.ObjectKey{Namespace: ns, Name: source.Name}
+}
+
+// readConfigMapSource returns the value of the key in the ConfigMap referenced by the source.
+// It fails with errConfigMapNotAllowed if the policy does not allow the WebSite to read the ConfigMap,
+// unless the source is one of the trusted ones given by BuildTemplate.
+func (r *WebSiteReconciler) readConfigMapSource(ctx context.Context, webSite *websitev1beta1.WebSite, source *websitev1beta1.ConfigMapSource, trusted []websitev1beta1.ConfigMapSource) (string, error) {
+	key := r.configMapKey(source)
+	if !slices.Contains(trusted, *source) && !r.configMapPolicy.Allows(webSite.Namespace, key.Namespace) {
+		return "", fmt.Errorf("%w: WebSites in %s cannot read ConfigMap %s:%s", errConfigMapNotAllowed, webSite.Namespace, key.Namespace, key.Name)
+	}
+	cm := &corev1.ConfigMap{}
+	err := r.client.Get(ctx, key, cm)
+	if err != nil {
+		return "", err
+	}
+	data, ok := cm.Data[source.Key]
+	if !ok {
+		return "", fmt.Errorf("ConfigMap %s:%s does not have %s", key.Namespace, key.Name, source.Key)
+	}
+	return data, nil
+}
+
+// referencedConfigMaps returns the ConfigMaps referenced by the WebSite in the form of "namespace/name".
+func (r *WebSiteReconciler) referencedConfigMaps(webSite *websitev1beta1.WebSite) []string {
+	sources := []*websitev1beta1.DataSource{&webSite.Spec.BuildScript, webSite.Spec.AfterBuildScript, webSite.Spec.NginxConf}
+	for i := range webSite.Spec.ExtraResources {
+		sources = append(sources, &webSite.Spec.ExtraResources[i])
+	}
+	return r.configMapKeys(sources)
+}
+
+// configMapKeys returns the ConfigMaps referenced by the sources in the form of "namespace/name".
+func (r *WebSiteReconciler) configMapKeys(sources []*websitev1beta1.DataSource) []string {
+	var keys []string
+	for _, source := range sources {
+		if source == nil || source.ConfigMap == nil {
+			continue
+		}
+		keys = append(keys, r.configMapKey(source.ConfigMap).String())
+	}
+	return keys
+}
+
+// referencedSecrets returns the Secrets referenced by the WebSite in the form of "namespace/name".
+func referencedSecrets(webSite *websitev1beta1.WebSite) []string {
+	var names []string
+	for _, secret := range webSite.Spec.BuildSecrets {
+		names = append(names, secret.Name)
+	}
+	if webSite.Spec.DeployKeySecretName != nil {
+		names = append(names, *webSite.Spec.DeployKeySecretName)
+	}
+	if webSite.Spec.WebhookSecret != nil {
+		names = append(names, webSite.Spec.WebhookSecret.Name)
+	}
+	if auth := webSite.Spec.GitAuth; auth != nil {
+		if auth.SecretName != nil {
+			names = append(names, *auth.SecretName)
+		}
+		if auth.GitHubApp != nil {
+			names = append(names, auth.GitHubApp.PrivateKeySecretName)
+		}
+	}
+
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = types.NamespacedName{Namespace: webSite.Namespace, Name: name}.String()
+	}
+	return keys
+}
+
+// referenceHandler returns the handler that enqueues the WebSites referring to the object by the index.
+func (r *WebSiteReconciler) referenceHandler(logger logr.Logger, indexField string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		wsl := &websitev1beta1.WebSiteList{}
+		err := r.client.List(ctx, wsl, client.MatchingFields{indexField: client.ObjectKeyFromObject(o).String()})
+		if err != nil {
+			logger.Error(err, "failed to list WebSites")
+			return nil
+		}
+		requests := make([]reconcile.Request, len(wsl.Items))
+		for i, ws := range wsl.Items {
+			requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ws)}
+		}
+		return requests
+	}
+}
+
+// buildSecretsHash returns the hash of the values of BuildSecrets, so that the site is built again when they are rotated.
+// It returns an empty string if the WebSite has no BuildSecrets.
+func (r *WebSiteReconciler) buildSecretsHash(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error) {
+	if len(webSite.Spec.BuildSecrets) == 0 {
+		return "", nil
+	}
+
+	h := md5.New()
+	for _, ref := range webSite.Spec.BuildSecrets {
+		secret := &corev1.Secret{}
+		err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: ref.Name}, secret)
+		if apierrors.IsNotFound(err) {
+			// the build reports the missing secret
+			fmt.Fprintf(h, "%s/%s:-;", ref.Name, ref.Key)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s/%s:%x;", ref.Name, ref.Key, md5.Sum(secret.Data[ref.Key]))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// webhookSecretHash returns the hash of the webhook secret, so that repo-checker is restarted to read the rotated secret.
+// It returns an empty string if the WebSite has no webhook secret.
+func (r *WebSiteReconciler) webhookSecretHash(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error) {
+	ref := webSite.Spec.WebhookSecret
+	if ref == nil {
+		return "", nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: ref.Name}, secret)
+	if apierrors.IsNotFound(err) {
+		// repo-checker cannot start without the secret, and it is restarted when the secret is created
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", md5.Sum(secret.Data[ref.Key])), nil
+}
+
+// combineHash returns the hash of the script combined with the hash of the other inputs of the script such as the secrets.
+// The script hash is returned as is if there are no other inputs, not to roll out the existing sites.
+func combineHash(scriptHash, inputHash string) string {
+	if len(scriptHash) == 0 || len(inputHash) == 0 {
+		return scriptHash
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(scriptHash+"/"+inputHash)))
+}
@@ -2,17 +2,38 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/cybozu-go/website-operator"
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
 	"github.com/go-logr/logr"
-	corev1 "k8s.io/api/core/v1"
+	"golang.org/x/sync/errgroup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+const (
+	// revisionWatcherConcurrency is the maximum number of repo-checkers polled at the same time.
+	revisionWatcherConcurrency = 10
+	// repoCheckerTimeout is the timeout of each request to repo-checker.
+	repoCheckerTimeout = 10 * time.Second
+	// slowRepoCheckerThreshold is the duration of a request to consider the repo-checker slow.
+	slowRepoCheckerThreshold = 3 * time.Second
+)
+
+// revisionPollBackoff is the backoff to retry the requests to repo-checker.
+var revisionPollBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    3,
+}
+
 func newRevisionWatcher(client client.Client, log logr.Logger, ch chan<- event.TypedGenericEvent[*websitev1beta1.WebSite], interval time.Duration, revCli RevisionClient) manager.Runnable {
 	return &revisionWatcher{
 		client:         client,
@@ -40,9 +61,10 @@ func (w revisionWatcher) Start(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			err := w.revisionChanged(context.Background())
+			// an error should not stop the manager, the sites are polled again at the next tick
+			err := w.revisionChanged(ctx)
 			if err != nil {
-				return err
+				w.log.Error(err, "failed to check the latest revisions")
 			}
 		}
 	}
@@ -50,25 +72,100 @@ func (w revisionWatcher) Start(ctx context.Context) error {
 
 func (w revisionWatcher) revisionChanged(ctx context.Context) error {
 	sites := websitev1beta1.WebSiteList{}
-	err := w.client.List(ctx, &sites, client.MatchingFields(map[string]string{website.WebSiteIndexField: string(corev1.ConditionTrue)}))
+	err := w.client.List(ctx, &sites, client.MatchingFields(map[string]string{website.WebSiteIndexField: string(metav1.ConditionTrue)}))
 	if err != nil {
 		return err
 	}
 
-	for _, site := range sites.Items {
-		latestRev, err := w.revisionClient.GetLatestRevision(ctx, &site)
-		if err != nil {
-			w.log.Error(err, "failed to get latest revision")
+	w.checkSites(ctx, sites.Items)
+	return nil
+}
+
+// checkSites polls the repo-checkers of the sites concurrently, and notifies the sites whose revision has been changed.
+func (w revisionWatcher) checkSites(ctx context.Context, sites []websitev1beta1.WebSite) {
+	g := new(errgroup.Group)
+	g.SetLimit(revisionWatcherConcurrency)
+	for i := range sites {
+		site := &sites[i]
+		if len(site.Spec.Revision) != 0 {
 			continue
 		}
-		if site.Status.Revision == latestRev {
-			continue
+		g.Go(func() error {
+			w.checkSite(ctx, site)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+func (w revisionWatcher) checkSite(ctx context.Context, site *websitev1beta1.WebSite) {
+	log := w.log.WithValues("website", client.ObjectKeyFromObject(site))
+
+	var latestRev Revision
+	err := retry.OnError(revisionPollBackoff, isRetriablePollError, func() error {
+		ctx, cancel := context.WithTimeout(ctx, repoCheckerTimeout)
+		defer cancel()
+
+		start := time.Now()
+		rev, err := w.revisionClient.GetLatestRevision(ctx, site)
+		elapsed := time.Since(start)
+		repoCheckerRequestSeconds.WithLabelValues(site.Namespace, site.Name).Observe(elapsed.Seconds())
+		if elapsed > slowRepoCheckerThreshold {
+			log.Info("repo-checker is slow", "elapsed", elapsed)
 		}
-		w.log.Info("revisionChanged", "currentRevision", site.Status.Revision, "latestRevision", latestRev)
-		ev := event.TypedGenericEvent[*websitev1beta1.WebSite]{
-			Object: site.DeepCopy(),
+		if err != nil {
+			return err
 		}
-		w.channel <- ev
+		latestRev = rev
+		return nil
+	})
+	if errors.Is(err, errRevisionNotReady) {
+		return
 	}
-	return nil
+	if err != nil {
+		log.Error(err, "failed to get latest revision")
+		revisionPollErrorsTotal.WithLabelValues(site.Namespace, site.Name).Inc()
+		return
+	}
+	// the metadata of the commit may be fetched by repo-checker after the revision
+	commitFetched := len(site.Spec.Revision) == 0 && site.Status.Commit == nil && latestRev.Commit != nil
+	if site.Status.Revision == latestRev.Hash && !commitFetched && !w.pullRequestsChanged(ctx, site) {
+		return
+	}
+	log.Info("revisionChanged", "currentRevision", site.Status.Revision, "latestRevision", latestRev.Hash)
+	ev := event.TypedGenericEvent[*websitev1beta1.WebSite]{
+		Object: site.DeepCopy(),
+	}
+	select {
+	case w.channel <- ev:
+	case <-ctx.Done():
+	}
+}
+
+func isRetriablePollError(err error) bool {
+	return !errors.Is(err, errRevisionNotReady) && !errors.Is(err, context.Canceled)
+}
+
+func (w revisionWatcher) pullRequestsChanged(ctx context.Context, site *websitev1beta1.WebSite) bool {
+	if site.Spec.Previews == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, repoCheckerTimeout)
+	defer cancel()
+	pulls, err := w.revisionClient.GetPullRequests(ctx, site)
+	if err != nil {
+		w.log.Error(err, "failed to get pull requests", "website", client.ObjectKeyFromObject(site))
+		revisionPollErrorsTotal.WithLabelValues(site.Namespace, site.Name).Inc()
+		return false
+	}
+	pulls = activePullRequests(site.Spec.Previews, pulls, time.Now())
+	if len(pulls) != len(site.Status.Previews) {
+		return true
+	}
+	for i, pr := range pulls {
+		if site.Status.Previews[i].Number != pr.Number || site.Status.Previews[i].Revision != pr.Revision {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// flakyRevisionClient fails the requests for broken sites, and fails the first request for the other sites.
+type flakyRevisionClient struct {
+	mu       sync.Mutex
+	broken   map[string]bool
+	requests map[string]int
+}
+
+func (c *flakyRevisionClient) GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (Revision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[webSite.Name]++
+	if c.broken[webSite.Name] || c.requests[webSite.Name] == 1 {
+		return Revision{}, errors.New("connection refused")
+	}
+	return Revision{Hash: "rev2"}, nil
+}
+
+func (c *flakyRevisionClient) GetPullRequests(ctx context.Context, webSite *websitev1beta1.WebSite) ([]checker.PullRequest, error) {
+	return nil, nil
+}
+
+func (c *flakyRevisionClient) UsesSharedRepoChecker(webSite *websitev1beta1.WebSite) bool {
+	return false
+}
+
+var _ = Describe("Revision watcher", func() {
+	It("should isolate the errors of each site and retry the requests", func() {
+		var sites []websitev1beta1.WebSite
+		for _, name := range []string{"site1", "site2", "site3"} {
+			sites = append(sites, websitev1beta1.WebSite{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: name},
+				Status:     websitev1beta1.WebSiteStatus{Revision: "rev1"},
+			})
+		}
+		revCli := &flakyRevisionClient{
+			broken:   map[string]bool{"site2": true},
+			requests: make(map[string]int),
+		}
+		ch := make(chan event.TypedGenericEvent[*websitev1beta1.WebSite], len(sites))
+		w := revisionWatcher{
+			log:            ctrl.Log.WithName("RevisionWatcher"),
+			channel:        ch,
+			revisionClient: revCli,
+		}
+
+		w.checkSites(context.Background(), sites)
+		close(ch)
+
+		var notified []string
+		for ev := range ch {
+			notified = append(notified, ev.Object.Name)
+		}
+		Expect(notified).Should(ConsistOf("site1", "site3"))
+		Expect(revCli.requests).Should(HaveKeyWithValue("site1", 2))
+		Expect(revCli.requests).Should(HaveKeyWithValue("site2", revisionPollBackoff.Steps))
+	})
+})
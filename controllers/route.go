@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const AppNameRoute = "route"
+
+// AnnIngressAnnotations is the annotation key of Ingresses that records the keys of the annotations applied from the WebSite,
+// so that the ones removed from the WebSite are also removed from the Ingress.
+const AnnIngressAnnotations = "website.zoetrope.github.io/ingress-annotations"
+
+// AnnHTTPRouteAnnotations is the annotation key of HTTPRoutes that records the keys of the annotations applied from the WebSite,
+// so that the ones removed from the WebSite are also removed from the HTTPRoute.
+const AnnHTTPRouteAnnotations = "website.zoetrope.github.io/httproute-annotations"
+
+var (
+	httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	gatewayGVK   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+)
+
+func newHTTPRoute() *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	return route
+}
+
+// publicHostAndPath returns the host and the path of PublicURL.
+func publicHostAndPath(webSite *websitev1beta1.WebSite) (string, string, error) {
+	u, err := url.Parse(webSite.Spec.PublicURL)
+	if err != nil {
+		return "", "", err
+	}
+	path := u.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	return u.Hostname(), path, nil
+}
+
+// reconcileRoute creates the Ingress or the HTTPRoute for PublicURL, and reports the admitted address.
+func (r *WebSiteReconciler) reconcileRoute(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	address := ""
+	if webSite.Spec.Ingress != nil {
+		ingress, err := r.reconcileIngress(ctx, webSite)
+		if err != nil {
+			return err
+		}
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if len(lb.IP) != 0 {
+				address = lb.IP
+			} else {
+				address = lb.Hostname
+			}
+			break
+		}
+	} else {
+		err := r.deleteOwnedObject(ctx, webSite, webSite.Name, &networkingv1.Ingress{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if webSite.Spec.HTTPRoute != nil {
+		route, err := r.reconcileHTTPRoute(ctx, webSite)
+		if err != nil {
+			return err
+		}
+		address, err = r.httpRouteAddress(ctx, webSite, route)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := r.deleteOwnedObject(ctx, webSite, webSite.Name, newHTTPRoute())
+		// the Gateway API may not be installed
+		if err != nil && !meta.IsNoMatchError(err) {
+			return err
+		}
+	}
+
+	webSite.Status.Address = address
+	return nil
+}
+
+func (r *WebSiteReconciler) reconcileIngress(ctx context.Context, webSite *websitev1beta1.WebSite) (*networkingv1.Ingress, error) {
+	log := r.log.WithValues("website", webSite.Name)
+
+	host, path, err := publicHostAndPath(webSite)
+	if err != nil {
+		return nil, err
+	}
+
+	ingress := &networkingv1.Ingress{}
+	ingress.SetNamespace(webSite.Namespace)
+	ingress.SetName(webSite.Name)
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, ingress, func() error {
+		setStandardLabels(AppNameRoute, &ingress.ObjectMeta)
+		ingress.Labels[InstanceKey] = webSite.Name
+		ingress.Annotations = applyAnnotations(ingress.Annotations, webSite.Spec.Ingress.Annotations, AnnIngressAnnotations)
+
+		pathType := networkingv1.PathTypePrefix
+		ingress.Spec.IngressClassName = webSite.Spec.Ingress.IngressClassName
+		ingress.Spec.Rules = []networkingv1.IngressRule{
+			{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{
+							{
+								Path:     path,
+								PathType: &pathType,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: webSite.Name,
+										Port: networkingv1.ServiceBackendPort{
+											Number: NginxPort,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		ingress.Spec.TLS = nil
+		if len(webSite.Spec.Ingress.TLSSecretName) != 0 {
+			ingress.Spec.TLS = []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{host},
+					SecretName: webSite.Spec.Ingress.TLSSecretName,
+				},
+			}
+		}
+		return ctrl.SetControllerReference(webSite, ingress, r.scheme)
+	})
+	if err != nil {
+		log.Error(err, "unable to create-or-update Ingress")
+		return nil, err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile Ingress successfully", "op", op)
+	}
+	return ingress, nil
+}
+
+// applyAnnotations sets the annotations given by the WebSite, and removes the ones that were given before but no longer.
+// The keys of the given annotations are recorded in the annotation of trackingKey,
+// and the annotations added by others are kept.
+func applyAnnotations(annotations, desired map[string]string, trackingKey string) map[string]string {
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, k := range strings.Split(annotations[trackingKey], ",") {
+		if _, ok := desired[k]; !ok {
+			delete(annotations, k)
+		}
+	}
+	for k, v := range desired {
+		annotations[k] = v
+	}
+	keys := slices.Sorted(maps.Keys(desired))
+	if len(keys) != 0 {
+		annotations[trackingKey] = strings.Join(keys, ",")
+	} else {
+		delete(annotations, trackingKey)
+	}
+	return annotations
+}
+
+func (r *WebSiteReconciler) reconcileHTTPRoute(ctx context.Context, webSite *websitev1beta1.WebSite) (*unstructured.Unstructured, error) {
+	log := r.log.WithValues("website", webSite.Name)
+
+	host, path, err := publicHostAndPath(webSite)
+	if err != nil {
+		return nil, err
+	}
+
+	route := newHTTPRoute()
+	route.SetNamespace(webSite.Namespace)
+	route.SetName(webSite.Name)
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.client, route, func() error {
+		return r.updateHTTPRoute(webSite, route, host, path)
+	})
+	if err != nil {
+		log.Error(err, "unable to create-or-update HTTPRoute")
+		return nil, err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconcile HTTPRoute successfully", "op", op)
+	}
+	return route, nil
+}
+
+// updateHTTPRoute sets the labels, the annotations and the spec of the HTTPRoute for the WebSite.
+func (r *WebSiteReconciler) updateHTTPRoute(webSite *websitev1beta1.WebSite, route *unstructured.Unstructured, host, path string) error {
+	labels := route.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[ManagedByKey] = OperatorName
+	labels[AppNameKey] = AppNameRoute
+	labels[InstanceKey] = webSite.Name
+	route.SetLabels(labels)
+	route.SetAnnotations(applyAnnotations(route.GetAnnotations(), webSite.Spec.HTTPRoute.Annotations, AnnHTTPRouteAnnotations))
+
+	var parentRefs []interface{}
+	for _, ref := range webSite.Spec.HTTPRoute.ParentRefs {
+		parentRef := map[string]interface{}{
+			"name": ref.Name,
+		}
+		if len(ref.Namespace) != 0 {
+			parentRef["namespace"] = ref.Namespace
+		}
+		if len(ref.SectionName) != 0 {
+			parentRef["sectionName"] = ref.SectionName
+		}
+		parentRefs = append(parentRefs, parentRef)
+	}
+	spec := map[string]interface{}{
+		"parentRefs": parentRefs,
+		"hostnames":  []interface{}{host},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{
+							"type":  "PathPrefix",
+							"value": path,
+						},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": webSite.Name,
+						"port": int64(NginxPort),
+					},
+				},
+			},
+		},
+	}
+	// ignore the default values filled by the API server
+	if !equality.Semantic.DeepDerivative(spec, route.Object["spec"]) {
+		route.Object["spec"] = spec
+	}
+	return ctrl.SetControllerReference(webSite, route, r.scheme)
+}
+
+// httpRouteAddress returns the address of the first Gateway that has accepted the HTTPRoute.
+func (r *WebSiteReconciler) httpRouteAddress(ctx context.Context, webSite *websitev1beta1.WebSite, route *unstructured.Unstructured) (string, error) {
+	parents, _, err := unstructured.NestedSlice(route.Object, "status", "parents")
+	if err != nil {
+		return "", err
+	}
+	for _, p := range parents {
+		parent, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditions, _, _ := unstructured.NestedSlice(parent, "conditions")
+		if !hasTrueCondition(conditions, "Accepted") {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(parent, "parentRef", "name")
+		namespace, _, _ := unstructured.NestedString(parent, "parentRef", "namespace")
+		if len(namespace) == 0 {
+			namespace = webSite.Namespace
+		}
+
+		gateway := &unstructured.Unstructured{}
+		gateway.SetGroupVersionKind(gatewayGVK)
+		err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, gateway)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+		for _, a := range addresses {
+			if addr, ok := a.(map[string]interface{}); ok {
+				if value, ok := addr["value"].(string); ok && len(value) != 0 {
+					return value, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func hasTrueCondition(conditions []interface{}, condType string) bool {
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] == condType && cond["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteOwnedObject deletes the object in the namespace of the WebSite if it is owned by the WebSite.
+func (r *WebSiteReconciler) deleteOwnedObject(ctx context.Context, webSite *websitev1beta1.WebSite, name string, obj client.Object) error {
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: webSite.Namespace, Name: name}, obj)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, webSite) {
+		return nil
+	}
+	err = r.client.Delete(ctx, obj)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	r.log.Info("delete owned object successfully", "website", webSite.Name, "name", name)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap/zapcore"
@@ -78,9 +79,19 @@ var _ = AfterSuite(func() {
 })
 
 type mockRevisionClient struct {
-	rev string
+	rev    string
+	pulls  []checker.PullRequest
+	shared bool
 }
 
-func (c mockRevisionClient) GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error) {
-	return c.rev, nil
+func (c mockRevisionClient) GetLatestRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (Revision, error) {
+	return Revision{Hash: c.rev}, nil
+}
+
+func (c mockRevisionClient) GetPullRequests(ctx context.Context, webSite *websitev1beta1.WebSite) ([]checker.PullRequest, error) {
+	return c.pulls, nil
+}
+
+func (c mockRevisionClient) UsesSharedRepoChecker(webSite *websitev1beta1.WebSite) bool {
+	return c.shared && !webSite.Spec.DedicatedRepoChecker
 }
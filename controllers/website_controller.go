@@ -1,29 +1,41 @@
 package controllers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/md5"
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/cybozu-go/website-operator"
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -52,42 +64,90 @@ const (
 	AfterBuildScriptName      = "after-build"
 	NginxPort                 = 8080
 	AnnChecksumConfig         = "checksum/config"
+	AnnChecksumWebhookSecret  = "checksum/webhook-secret"
+	AnnArtifact               = "website.zoetrope.github.io/artifact"
 )
 
-func NewWebSiteReconciler(client client.Client, log logr.Logger, scheme *runtime.Scheme, nginxContainerImage string, repoCheckerContainerImage string, operatorNamespace string, revCli RevisionClient) *WebSiteReconciler {
+const (
+	// buildKillAfter is the grace period to kill the build that has not exited after the timeout.
+	buildKillAfter = "30s"
+	// timeoutExitCode is the exit code of timeout(1) when the command times out.
+	timeoutExitCode = 124
+	// timeoutKilledExitCode is the exit code of timeout(1) when the command is killed after the grace period.
+	timeoutKilledExitCode = 128 + 9
+)
+
+// timeoutSeconds rounds up the timeout to seconds.
+func timeoutSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// NewWebSiteReconciler creates WebSiteReconciler.
+// Secrets are read by apiReader without the cache, not to keep all the Secrets in the cluster in memory.
+func NewWebSiteReconciler(client client.Client, apiReader client.Reader, log logr.Logger, scheme *runtime.Scheme, nginxContainerImage string, repoCheckerContainerImage string, operatorNamespace string, revCli RevisionClient, notifyBindAddress string, notifyURL string, watchInterval time.Duration, configMapPolicy *ConfigMapPolicy, gitHubAPIURLs []string) *WebSiteReconciler {
+	if len(gitHubAPIURLs) == 0 {
+		gitHubAPIURLs = []string{defaultGitHubAPIURL}
+	}
+	apiURLs := make([]string, len(gitHubAPIURLs))
+	for i, u := range gitHubAPIURLs {
+		apiURLs[i] = strings.TrimSuffix(u, "/")
+	}
 	return &WebSiteReconciler{
 		client:                    client,
+		apiReader:                 apiReader,
 		log:                       log,
 		scheme:                    scheme,
 		nginxContainerImage:       nginxContainerImage,
 		repoCheckerContainerImage: repoCheckerContainerImage,
 		operatorNamespace:         operatorNamespace,
 		revisionClient:            revCli,
+		notifyBindAddress:         notifyBindAddress,
+		notifyURL:                 notifyURL,
+		watchInterval:             watchInterval,
+		configMapPolicy:           configMapPolicy,
+		gitHubAPIURLs:             apiURLs,
 	}
 }
 
 // WebSiteReconciler reconciles a WebSite object
 type WebSiteReconciler struct {
 	client                    client.Client
+	apiReader                 client.Reader
 	log                       logr.Logger
 	scheme                    *runtime.Scheme
 	nginxContainerImage       string
 	repoCheckerContainerImage string
 	operatorNamespace         string
 	revisionClient            RevisionClient
+	notifyBindAddress         string
+	notifyURL                 string
+	watchInterval             time.Duration
+	configMapPolicy           *ConfigMapPolicy
+	gitHubAPIURLs             []string
+	recorder                  record.EventRecorder
+	discovery                 discovery.DiscoveryInterface
+	detection                 revisionDetection
 }
 
 //+kubebuilder:rbac:groups=website.zoetrope.github.io,resources=websites,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=website.zoetrope.github.io,resources=websites/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=website.zoetrope.github.io,resources=websites/finalizers,verbs=update
+//+kubebuilder:rbac:groups=website.zoetrope.github.io,resources=buildtemplates,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=services/status,verbs=get
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=configmaps/status,verbs=get
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get
 //+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="batch",resources=jobs/status,verbs=get
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get
 
 func (r *WebSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.log.WithValues("website", req.NamespacedName)
@@ -95,6 +155,9 @@ func (r *WebSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	webSite := &websitev1beta1.WebSite{}
 	if err := r.client.Get(ctx, req.NamespacedName, webSite); err != nil {
 		log.Error(err, "unable to fetch WebSite", "name", req.NamespacedName)
+		if apierrors.IsNotFound(err) {
+			r.deleteWebSiteMetrics(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
@@ -102,120 +165,296 @@ func (r *WebSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil
 	}
 
-	isUpdatedAtLeastOnce, revision, err := r.reconcile(ctx, webSite)
+	status := webSite.Status.DeepCopy()
+	revision, err := r.reconcile(ctx, webSite)
+	if len(revision) != 0 {
+		webSite.Status.Revision = revision
+	}
+	addRevisionHistory(webSite)
+	webSite.Status.ObservedGeneration = webSite.Generation
+	setReadyCondition(webSite)
+	r.recordConditionEvents(webSite, status.Conditions)
+	r.updateRevisionMetrics(webSite, status.Revision, status.Conditions)
+	observeBuildMetrics(webSite, status.Conditions)
+	if !equality.Semantic.DeepEqual(status, &webSite.Status) {
+		errUpdate := r.client.Status().Update(ctx, webSite)
+		if errUpdate != nil {
+			log.Error(errUpdate, "failed to status update")
+			if err == nil {
+				return ctrl.Result{}, errUpdate
+			}
+		}
+	}
+
 	if errors.Is(err, errRevisionNotReady) {
 		return ctrl.Result{
 			Requeue: true,
 		}, nil
 	}
+	if errors.Is(err, errConfigMapNotAllowed) || errors.Is(err, errGitHubAPIURLNotAllowed) {
+		// retrying does not help until the WebSite is fixed
+		return ctrl.Result{}, nil
+	}
 	if errors.Is(err, errJobIsActive) {
 		return ctrl.Result{
 			RequeueAfter: 10 * time.Second,
 		}, nil
 	}
-	if err != nil {
-		webSite.Status.Ready = corev1.ConditionFalse
-		webSite.Status.Revision = revision
-		errUpdate := r.client.Status().Update(ctx, webSite)
-		if errUpdate != nil {
-			log.Error(errUpdate, "failed to status update")
-		}
-		return ctrl.Result{}, err
-	}
-
-	if isUpdatedAtLeastOnce || webSite.Status.Ready == corev1.ConditionFalse {
-		webSite.Status.Ready = corev1.ConditionTrue
-		webSite.Status.Revision = revision
-		errUpdate := r.client.Status().Update(ctx, webSite)
-		if errUpdate != nil {
-			log.Error(errUpdate, "failed to status update")
-			return ctrl.Result{}, err
-		}
+	if err == nil && usesGitHubApp(webSite) {
+		// refresh the installation token before it expires
+		return ctrl.Result{
+			RequeueAfter: gitHubTokenCheckInterval,
+		}, nil
 	}
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, err
 }
 
-func (r *WebSiteReconciler) reconcile(ctx context.Context, webSite *websitev1beta1.WebSite) (bool, string, error) {
+func (r *WebSiteReconciler) reconcile(ctx context.Context, webSite *websitev1beta1.WebSite) (string, error) {
 	log := r.log.WithValues("website", webSite.Name)
 
-	isUpdatedAtLeastOnce := false
+	stored := webSite.Spec.DeepCopy()
+	trusted, err := r.applyBuildTemplate(ctx, webSite)
+	if err != nil {
+		log.Error(err, "failed to apply BuildTemplate")
+		setCondition(webSite, websitev1beta1.ConditionConfigRendered, metav1.ConditionFalse, ReasonBuildTemplateError, err.Error())
+		return "", err
+	}
 
-	isUpdated, buildScriptHash, err := r.reconcileScriptConfigMap(ctx, webSite, &webSite.Spec.BuildScript, BuildScriptName)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	_, buildScriptHash, err := r.reconcileScriptConfigMap(ctx, webSite, &webSite.Spec.BuildScript, BuildScriptName, trusted)
 	if err != nil {
 		log.Error(err, "failed to create ConfigMap for build script")
-		return isUpdatedAtLeastOnce, "", err
+		r.recordConfigMapError(webSite, websitev1beta1.ConditionConfigRendered, err)
+		setCondition(webSite, websitev1beta1.ConditionConfigRendered, metav1.ConditionFalse, configMapErrorReason(err, ReasonBuildScriptError), err.Error())
+		return "", err
 	}
 
-	isUpdated, afterBuildScriptHash, err := r.reconcileScriptConfigMap(ctx, webSite, webSite.Spec.AfterBuildScript, AfterBuildScriptName)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	_, afterBuildScriptHash, err := r.reconcileScriptConfigMap(ctx, webSite, webSite.Spec.AfterBuildScript, AfterBuildScriptName, trusted)
 	if err != nil {
 		log.Error(err, "failed to create ConfigMap for after build script")
-		return isUpdatedAtLeastOnce, "", err
+		r.recordConfigMapError(webSite, websitev1beta1.ConditionConfigRendered, err)
+		setCondition(webSite, websitev1beta1.ConditionConfigRendered, metav1.ConditionFalse, configMapErrorReason(err, ReasonAfterBuildScriptError), err.Error())
+		return "", err
 	}
 
-	isUpdated, err = r.reconcileRepoCheckerDeployment(ctx, webSite)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	err = r.reconcileGitAuth(ctx, webSite)
 	if err != nil {
-		log.Error(err, "failed to reconcile RepoChecker deployment")
-		return isUpdatedAtLeastOnce, "", err
+		log.Error(err, "failed to reconcile the credentials of the repository")
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonGitAuthError, err.Error())
+		return "", err
 	}
 
-	isUpdated, err = r.reconcileRepoCheckerService(ctx, webSite)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	err = r.reconcileBuildCachePVC(ctx, webSite)
 	if err != nil {
-		log.Error(err, "failed to create or update Service For RepoChecker")
-		return isUpdatedAtLeastOnce, "", err
+		log.Error(err, "failed to reconcile the build cache")
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, ReasonBuildCacheError, err.Error())
+		return "", err
 	}
 
-	revision, err := r.revisionClient.GetLatestRevision(ctx, webSite)
+	// rotating the build secrets rebuilds the site as well as editing the scripts
+	secretsHash, err := r.buildSecretsHash(ctx, webSite)
+	if err != nil {
+		log.Error(err, "failed to get the build secrets")
+		setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, ReasonBuildSecretsError, err.Error())
+		return "", err
+	}
+	buildScriptHash = combineHash(combineHash(buildScriptHash, secretsHash), buildTemplateParametersHash(webSite))
+	afterBuildScriptHash = combineHash(combineHash(afterBuildScriptHash, secretsHash), buildTemplateParametersHash(webSite))
+
+	// previews are pinned to the head of the pull request, so they need no repo-checker
+	if r.revisionClient.UsesSharedRepoChecker(webSite) {
+		err = r.deleteRepoChecker(ctx, webSite)
+		if err != nil {
+			log.Error(err, "failed to delete RepoChecker replaced by the shared one")
+			setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonRepoCheckerError, err.Error())
+			return "", err
+		}
+	} else if !isPreview(webSite) {
+		_, err = r.reconcileRepoCheckerDeployment(ctx, webSite)
+		if err != nil {
+			log.Error(err, "failed to reconcile RepoChecker deployment")
+			setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonRepoCheckerError, err.Error())
+			return "", err
+		}
+
+		_, err = r.reconcileRepoCheckerService(ctx, webSite)
+		if err != nil {
+			log.Error(err, "failed to create or update Service For RepoChecker")
+			setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonRepoCheckerError, err.Error())
+			return "", err
+		}
+	}
+
+	latest, err := r.getTargetRevision(ctx, webSite)
+	if errors.Is(err, errRevisionNotReady) {
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonRevisionNotReady, "repo-checker has not fetched the revision yet")
+		return "", err
+	}
 	if err != nil {
 		log.Error(err, "failed to get revision from RepoChecker")
-		return isUpdatedAtLeastOnce, "", err
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionFalse, ReasonRevisionCheckFailed, err.Error())
+		return "", err
+	}
+	revision := latest.Hash
+	if revision != webSite.Status.Revision {
+		r.detection.detect(types.NamespacedName{Namespace: webSite.Namespace, Name: webSite.Name}, revision)
+	}
+	if len(webSite.Spec.Revision) != 0 {
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionTrue, ReasonPinned, "revision "+revision+" is pinned")
+	} else if len(latest.Tag) != 0 {
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionTrue, ReasonResolved, "revision "+revision+" (tag "+latest.Tag+")")
+	} else {
+		setCondition(webSite, websitev1beta1.ConditionRevisionResolved, metav1.ConditionTrue, ReasonResolved, "revision "+revision)
+	}
+
+	if !isPreview(webSite) {
+		// previews should not prevent the website itself from being deployed
+		err = r.reconcilePreviews(ctx, webSite, stored)
+		if err != nil {
+			log.Error(err, "failed to reconcile previews")
+		}
 	}
 
-	isUpdated, nginxConfHash, err := r.reconcileNginxConfigMap(ctx, webSite, webSite.Spec.NginxConf)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	_, nginxConfHash, err := r.reconcileNginxConfigMap(ctx, webSite, webSite.Spec.NginxConf, trusted)
 	if err != nil {
 		log.Error(err, "failed to create or update nginx.conf")
-		return isUpdatedAtLeastOnce, revision, err
+		r.recordConfigMapError(webSite, websitev1beta1.ConditionConfigRendered, err)
+		setCondition(webSite, websitev1beta1.ConditionConfigRendered, metav1.ConditionFalse, configMapErrorReason(err, ReasonNginxConfError), err.Error())
+		return revision, err
 	}
+	setCondition(webSite, websitev1beta1.ConditionConfigRendered, metav1.ConditionTrue, ReasonRendered, "")
 
-	isUpdated, err = r.reconcileNginxDeployment(ctx, webSite, revision, buildScriptHash, nginxConfHash)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
-	if err != nil {
-		log.Error(err, "failed to create or update Deployment For Nginx")
-		return isUpdatedAtLeastOnce, revision, err
+	artifact := ""
+	if webSite.Spec.Artifacts != nil {
+		revision, artifact, err = r.reconcileBuild(ctx, webSite, revision, buildScriptHash)
+		if err != nil {
+			log.Error(err, "failed to build the website by Job")
+			setCondition(webSite, websitev1beta1.ConditionBuildSucceeded, metav1.ConditionFalse, ReasonJobError, err.Error())
+			return "", err
+		}
+		if len(artifact) == 0 {
+			setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonUnavailable, "waiting for the first build")
+			return "", nil
+		}
+	} else {
+		webSite.Status.Artifacts = nil
 	}
 
-	isUpdated, err = r.reconcileNginxService(ctx, webSite)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	if isBlueGreen(webSite) {
+		revision, err = r.reconcileBlueGreen(ctx, webSite, revision, artifact, buildScriptHash, nginxConfHash)
+		if err != nil {
+			log.Error(err, "failed to roll out Deployment For Nginx")
+			setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonDeploymentError, err.Error())
+			return "", err
+		}
+	} else {
+		_, err = r.reconcileNginxDeployment(ctx, webSite, revision, artifact, buildScriptHash, nginxConfHash)
+		if err != nil {
+			log.Error(err, "failed to create or update Deployment For Nginx")
+			setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonDeploymentError, err.Error())
+			return revision, err
+		}
+	}
+	// the tag and the commit are kept while the previous revision is served
+	if revision == latest.Hash {
+		webSite.Status.Tag = latest.Tag
+		webSite.Status.Commit = latest.Commit
+	}
+
+	_, err = r.reconcileNginxService(ctx, webSite)
 	if err != nil {
 		log.Error(err, "failed to create or update Service For Nginx")
-		return isUpdatedAtLeastOnce, "", err
+		setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonServiceError, err.Error())
+		return revision, err
 	}
 
-	isUpdated, err = r.reconcileExtraResources(ctx, webSite)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	err = r.reconcileRoute(ctx, webSite)
+	if err != nil {
+		log.Error(err, "failed to create or update the route for PublicURL")
+		setCondition(webSite, websitev1beta1.ConditionNginxAvailable, metav1.ConditionFalse, ReasonRouteError, err.Error())
+		return revision, err
+	}
+
+	if !isBlueGreen(webSite) {
+		err = r.updateNginxConditions(ctx, webSite, webSite.Name, revision)
+		if err != nil {
+			log.Error(err, "failed to check Deployment For Nginx")
+			return revision, err
+		}
+		err = r.cleanupBlueGreen(ctx, webSite)
+		if err != nil {
+			log.Error(err, "failed to clean up Deployments for BlueGreen strategy")
+			return revision, err
+		}
+	}
+	// nothing is served until the first rollout of the BlueGreen strategy completes
+	if len(revision) == 0 {
+		return "", nil
+	}
+
+	_, err = r.reconcileExtraResources(ctx, webSite, revision)
 	if err != nil {
 		log.Error(err, "failed to create extraResources")
-		return isUpdatedAtLeastOnce, "", err
+		r.recordConfigMapError(webSite, websitev1beta1.ConditionExtraResourcesApplied, err)
+		setCondition(webSite, websitev1beta1.ConditionExtraResourcesApplied, metav1.ConditionFalse, configMapErrorReason(err, ReasonApplyFailed), err.Error())
+		return revision, err
 	}
+	setCondition(webSite, websitev1beta1.ConditionExtraResourcesApplied, metav1.ConditionTrue, ReasonApplied, "")
 
-	isUpdated, err = r.reconcileAfterBuildScript(ctx, webSite, revision, afterBuildScriptHash)
-	isUpdatedAtLeastOnce = isUpdatedAtLeastOnce || isUpdated
+	_, err = r.reconcileAfterBuildScript(ctx, webSite, revision, afterBuildScriptHash)
 	if err == errJobIsActive {
-		return isUpdatedAtLeastOnce, "", err
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobRunning, "after-build Job is running")
+		return revision, err
 	}
 	if err != nil {
 		log.Error(err, "failed to create Job for AfterBuildScript")
-		return isUpdatedAtLeastOnce, "", err
+		setCondition(webSite, websitev1beta1.ConditionAfterBuildCompleted, metav1.ConditionFalse, ReasonJobError, err.Error())
+		return revision, err
+	}
+
+	err = r.updateAfterBuildCondition(ctx, webSite)
+	if err != nil {
+		log.Error(err, "failed to check Job for AfterBuildScript")
+		return revision, err
+	}
+
+	return revision, nil
+}
+
+// getTargetRevision returns the revision to deploy.
+func (r *WebSiteReconciler) getTargetRevision(ctx context.Context, webSite *websitev1beta1.WebSite) (Revision, error) {
+	if len(webSite.Spec.Revision) != 0 {
+		return Revision{Hash: webSite.Spec.Revision}, nil
 	}
+	return r.revisionClient.GetLatestRevision(ctx, webSite)
+}
 
-	return isUpdatedAtLeastOnce, revision, nil
+// addRevisionHistory records the revision at the head of the history once it is built and served by nginx.
+func addRevisionHistory(webSite *websitev1beta1.WebSite) {
+	revision := webSite.Status.Revision
+	if len(revision) == 0 || !isRevisionServed(webSite.Status.Conditions) {
+		return
+	}
+	if len(webSite.Status.History) != 0 && webSite.Status.History[0].Revision == revision {
+		return
+	}
+	limit := 10
+	if webSite.Spec.RevisionHistoryLimit != nil {
+		limit = int(*webSite.Spec.RevisionHistoryLimit)
+	}
+	history := append([]websitev1beta1.RevisionHistory{
+		{
+			Revision:   revision,
+			Tag:        webSite.Status.Tag,
+			DeployedAt: metav1.Now(),
+		},
+	}, webSite.Status.History...)
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	webSite.Status.History = history
 }
 
-func (r *WebSiteReconciler) reconcileScriptConfigMap(ctx context.Context, webSite *websitev1beta1.WebSite, source *websitev1beta1.DataSource, scriptType string) (bool, string, error) {
+func (r *WebSiteReconciler) reconcileScriptConfigMap(ctx context.Context, webSite *websitev1beta1.WebSite, source *websitev1beta1.DataSource, scriptType string, trusted []websitev1beta1.ConfigMapSource) (bool, string, error) {
 	log := r.log.WithValues("website", webSite.Name)
 
 	if source == nil {
@@ -226,20 +465,11 @@ func (r *WebSiteReconciler) reconcileScriptConfigMap(ctx context.Context, webSit
 	if source.RawData != nil {
 		script = *source.RawData
 	} else if source.ConfigMap != nil {
-		buildScriptConfigMap := &corev1.ConfigMap{}
-		ns := r.operatorNamespace
-		if len((*source.ConfigMap).Namespace) != 0 {
-			ns = (*source.ConfigMap).Namespace
-		}
-		err := r.client.Get(ctx, client.ObjectKey{Namespace: ns, Name: (*source.ConfigMap).Name}, buildScriptConfigMap)
+		var err error
+		script, err = r.readConfigMapSource(ctx, webSite, source.ConfigMap, trusted)
 		if err != nil {
 			return false, "", err
 		}
-		var ok bool
-		script, ok = buildScriptConfigMap.Data[(*source.ConfigMap).Key]
-		if !ok {
-			return false, "", fmt.Errorf("ConfigMap %s:%s does not have %s", ns, (*source.ConfigMap).Name, (*source.ConfigMap).Key)
-		}
 	} else {
 		return false, "", errors.New("buildScript should not be empty")
 	}
@@ -277,6 +507,11 @@ func (r *WebSiteReconciler) reconcileScriptConfigMap(ctx context.Context, webSit
 func (r *WebSiteReconciler) reconcileRepoCheckerDeployment(ctx context.Context, webSite *websitev1beta1.WebSite) (bool, error) {
 	log := r.log.WithValues("website", webSite.Name)
 
+	secretHash, err := r.webhookSecretHash(ctx, webSite)
+	if err != nil {
+		return false, err
+	}
+
 	deployment := &appsv1.Deployment{}
 	deployment.SetNamespace(webSite.Namespace)
 	deployment.SetName(webSite.Name + RepoCheckerSuffix)
@@ -291,7 +526,7 @@ func (r *WebSiteReconciler) reconcileRepoCheckerDeployment(ctx context.Context,
 		deployment.Spec.Selector.MatchLabels[ManagedByKey] = OperatorName
 		deployment.Spec.Selector.MatchLabels[AppNameKey] = AppNameRepoChecker
 
-		podTemplate, err := r.makePodTemplateForRepoChecker(webSite)
+		podTemplate, err := r.makePodTemplateForRepoChecker(webSite, secretHash)
 		if err != nil {
 			return err
 		}
@@ -313,7 +548,18 @@ func (r *WebSiteReconciler) reconcileRepoCheckerDeployment(ctx context.Context,
 	return false, nil
 }
 
-func (r *WebSiteReconciler) makePodTemplateForRepoChecker(webSite *websitev1beta1.WebSite) (*corev1.PodTemplateSpec, error) {
+// deleteRepoChecker deletes the repo-checker of the WebSite that has been switched to the shared repo-checker.
+func (r *WebSiteReconciler) deleteRepoChecker(ctx context.Context, webSite *websitev1beta1.WebSite) error {
+	err := r.deleteOwnedObject(ctx, webSite, webSite.Name+RepoCheckerSuffix, &appsv1.Deployment{})
+	if err != nil {
+		return err
+	}
+	return r.deleteOwnedObject(ctx, webSite, webSite.Name+RepoCheckerSuffix, &corev1.Service{})
+}
+
+// makePodTemplateForRepoChecker returns the pod template of repo-checker.
+// webhookSecretHash is recorded in the annotation, so that repo-checker reads the rotated webhook secret.
+func (r *WebSiteReconciler) makePodTemplateForRepoChecker(webSite *websitev1beta1.WebSite, webhookSecretHash string) (*corev1.PodTemplateSpec, error) {
 	newTemplate := corev1.PodTemplateSpec{}
 
 	newTemplate.Labels = make(map[string]string)
@@ -329,6 +575,9 @@ func (r *WebSiteReconciler) makePodTemplateForRepoChecker(webSite *websitev1beta
 	newTemplate.Labels[ManagedByKey] = OperatorName
 	newTemplate.Labels[AppNameKey] = AppNameRepoChecker
 	newTemplate.Labels[InstanceKey] = webSite.Name + RepoCheckerSuffix
+	if len(webhookSecretHash) != 0 {
+		newTemplate.Annotations[AnnChecksumWebhookSecret] = webhookSecretHash
+	}
 
 	container := corev1.Container{
 		Name:  "repo-checker",
@@ -345,6 +594,30 @@ func (r *WebSiteReconciler) makePodTemplateForRepoChecker(webSite *websitev1beta
 			},
 		),
 	}
+	if len(webSite.Spec.Tag) != 0 {
+		container.Command = append(container.Command, fmt.Sprintf("--repo-tag=%s", webSite.Spec.Tag))
+	}
+	if interval := pollInterval(webSite); interval != 0 {
+		container.Command = append(container.Command, fmt.Sprintf("--interval=%s", interval))
+	}
+	if webSite.Spec.WebhookSecret != nil {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name: checker.WebhookSecretEnv,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: webSite.Spec.WebhookSecret.Name,
+					},
+					Key: webSite.Spec.WebhookSecret.Key,
+				},
+			},
+		})
+		if len(r.notifyURL) != 0 {
+			container.Command = append(container.Command,
+				fmt.Sprintf("--notify-url=%s%s%s/%s", strings.TrimSuffix(r.notifyURL, "/"), NotifyPathPrefix, webSite.Namespace, webSite.Name),
+			)
+		}
+	}
 
 	newTemplate.Spec.SecurityContext = &corev1.PodSecurityContext{
 		RunAsUser: ptr.To[int64](10000),
@@ -369,6 +642,15 @@ func (r *WebSiteReconciler) makePodTemplateForRepoChecker(webSite *websitev1beta
 				MountPath: "/home/ubuntu/.ssh",
 			},
 		)
+		container.Command = append(container.Command, "--ssh-key-file=/home/ubuntu/.ssh/id_rsa")
+		if webSite.Spec.InsecureIgnoreHostKey {
+			container.Command = append(container.Command, "--insecure-ignore-host-key")
+		}
+	}
+	if webSite.Spec.GitAuth != nil {
+		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, makeGitAuthVolume(webSite))
+		container.VolumeMounts = append(container.VolumeMounts, makeGitAuthVolumeMount())
+		container.Command = append(container.Command, "--credentials-dir="+GitAuthPath)
 	}
 	for _, secret := range webSite.Spec.ImagePullSecrets {
 		newTemplate.Spec.ImagePullSecrets = append(newTemplate.Spec.ImagePullSecrets, secret)
@@ -448,7 +730,7 @@ func (r *WebSiteReconciler) reconcileRepoCheckerService(ctx context.Context, web
 
 var errRevisionNotReady = errors.New("latest revision not ready")
 
-func (r *WebSiteReconciler) reconcileNginxDeployment(ctx context.Context, webSite *websitev1beta1.WebSite, revision string, buildScriptHash, nginxConfHash string) (bool, error) {
+func (r *WebSiteReconciler) reconcileNginxDeployment(ctx context.Context, webSite *websitev1beta1.WebSite, revision, artifact string, buildScriptHash, nginxConfHash string) (bool, error) {
 	log := r.log.WithValues("website", webSite.Name)
 	deployment := &appsv1.Deployment{}
 	deployment.SetNamespace(webSite.Namespace)
@@ -464,7 +746,7 @@ func (r *WebSiteReconciler) reconcileNginxDeployment(ctx context.Context, webSit
 		deployment.Spec.Selector.MatchLabels[ManagedByKey] = OperatorName
 		deployment.Spec.Selector.MatchLabels[AppNameKey] = AppNameNginx
 
-		podTemplate, err := r.makeNginxPodTemplate(ctx, webSite, revision, buildScriptHash, nginxConfHash)
+		podTemplate, err := r.makeNginxPodTemplate(ctx, webSite, revision, artifact, buildScriptHash, nginxConfHash)
 		if err != nil {
 			return err
 		}
@@ -487,7 +769,7 @@ func (r *WebSiteReconciler) reconcileNginxDeployment(ctx context.Context, webSit
 	return false, nil
 }
 
-func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *websitev1beta1.WebSite, revision string, buildScriptHash, nginxConfHash string) (*corev1.PodTemplateSpec, error) {
+func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *websitev1beta1.WebSite, revision, artifact string, buildScriptHash, nginxConfHash string) (*corev1.PodTemplateSpec, error) {
 	newTemplate := corev1.PodTemplateSpec{}
 
 	newTemplate.Labels = make(map[string]string)
@@ -504,26 +786,24 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 	newTemplate.Labels[ManagedByKey] = OperatorName
 	newTemplate.Labels[AppNameKey] = AppNameNginx
 	newTemplate.Labels[InstanceKey] = webSite.Name
-	newTemplate.Annotations[AnnChecksumConfig] = buildScriptHash + "-" + nginxConfHash
+	if len(artifact) == 0 {
+		newTemplate.Annotations[AnnChecksumConfig] = buildScriptHash + "-" + nginxConfHash
+	} else {
+		// the artifact already reflects the build script
+		newTemplate.Annotations[AnnChecksumConfig] = nginxConfHash
+		newTemplate.Annotations[AnnArtifact] = artifact
+	}
 
 	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, getVolumeOrEmptyDir(webSite, "data"))
 	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, getVolumeOrEmptyDir(webSite, "log"))
 	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, getVolumeOrEmptyDir(webSite, "cache"))
 	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, getVolumeOrEmptyDir(webSite, "tmp"))
-	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, getVolumeOrEmptyDir(webSite, "home"))
-
+	if len(artifact) == 0 {
+		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, makeBuildVolumes(webSite)...)
+	} else {
+		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, makeArtifactsVolume(webSite))
+	}
 	newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes,
-		corev1.Volume{
-			Name: BuildScriptName + "-script",
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: webSite.Name + "-" + BuildScriptName + "-script",
-					},
-					DefaultMode: ptr.To[int32](0755),
-				},
-			},
-		},
 		corev1.Volume{
 			Name: "nginx-conf",
 			VolumeSource: corev1.VolumeSource{
@@ -536,18 +816,11 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 			},
 		},
 	)
-	if webSite.Spec.DeployKeySecretName != nil {
-		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes,
-			corev1.Volume{
-				Name: "deploy-key",
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName:  *webSite.Spec.DeployKeySecretName,
-						DefaultMode: ptr.To[int32](0600),
-					},
-				},
-			},
-		)
+	if len(artifact) == 0 && webSite.Spec.DeployKeySecretName != nil {
+		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, makeDeployKeyVolume(webSite))
+	}
+	if len(artifact) == 0 && webSite.Spec.GitAuth != nil {
+		newTemplate.Spec.Volumes = append(newTemplate.Spec.Volumes, makeGitAuthVolume(webSite))
 	}
 	newTemplate.Spec.SecurityContext = &corev1.PodSecurityContext{
 		FSGroup: ptr.To[int64](10000),
@@ -598,6 +871,60 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 	})
 
 	// create init containers and append them to Pod
+	if len(artifact) == 0 {
+		if purgeContainer, ok := makePurgeBuildCacheContainer(webSite); ok {
+			newTemplate.Spec.InitContainers = append(newTemplate.Spec.InitContainers, purgeContainer)
+		}
+		buildContainer := makeBuildContainer(webSite, revision, corev1.VolumeMount{MountPath: "/data", Name: "data"}, "/data")
+		newTemplate.Spec.InitContainers = append(newTemplate.Spec.InitContainers, buildContainer)
+	} else {
+		newTemplate.Spec.InitContainers = append(newTemplate.Spec.InitContainers, r.makeFetchContainer(revision, artifact))
+	}
+
+	for _, secret := range webSite.Spec.ImagePullSecrets {
+		newTemplate.Spec.ImagePullSecrets = append(newTemplate.Spec.ImagePullSecrets, secret)
+	}
+
+	return &newTemplate, nil
+}
+
+// makeBuildVolumes returns the volumes used by the build container except for the output and the deploy key.
+func makeBuildVolumes(webSite *websitev1beta1.WebSite) []corev1.Volume {
+	volumes := []corev1.Volume{
+		getVolumeOrEmptyDir(webSite, "home"),
+		{
+			Name: BuildScriptName + "-script",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: webSite.Name + "-" + BuildScriptName + "-script",
+					},
+					DefaultMode: ptr.To[int32](0755),
+				},
+			},
+		},
+	}
+	if usesBuildCache(webSite) {
+		volumes = append(volumes, makeBuildCacheVolume(webSite))
+	}
+	return volumes
+}
+
+func makeDeployKeyVolume(webSite *websitev1beta1.WebSite) corev1.Volume {
+	return corev1.Volume{
+		Name: "deploy-key",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  *webSite.Spec.DeployKeySecretName,
+				DefaultMode: ptr.To[int32](0600),
+			},
+		},
+	}
+}
+
+// makeBuildContainer returns the container that runs the build script and writes the website to output.
+// outputMount is the volume mount that contains the output directory.
+func makeBuildContainer(webSite *websitev1beta1.WebSite, revision string, outputMount corev1.VolumeMount, output string) corev1.Container {
 	buildContainer := corev1.Container{
 		Name:    "build",
 		Image:   webSite.Spec.BuildImage,
@@ -614,10 +941,7 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 				MountPath: "/build",
 				Name:      BuildScriptName + "-script",
 			},
-			{
-				MountPath: "/data",
-				Name:      "data",
-			},
+			outputMount,
 			{
 				MountPath: "/tmp",
 				Name:      "tmp",
@@ -634,7 +958,7 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 			},
 			corev1.EnvVar{
 				Name:  "OUTPUT",
-				Value: "/data",
+				Value: output,
 			},
 		),
 	}
@@ -645,7 +969,29 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 			Name:      "deploy-key",
 		})
 	}
+	if webSite.Spec.GitAuth != nil {
+		buildContainer.VolumeMounts = append(buildContainer.VolumeMounts, makeGitAuthVolumeMount())
+		buildContainer.Env = append(buildContainer.Env, makeGitAuthEnv()...)
+	}
+	if usesBuildCache(webSite) {
+		buildContainer.VolumeMounts = append(buildContainer.VolumeMounts, makeBuildCacheVolumeMount())
+		buildContainer.Env = append(buildContainer.Env, makeBuildCacheEnv()...)
+	}
+	if build := webSite.Spec.Build; build != nil {
+		buildContainer.Resources = build.Resources
+		if build.Timeout != nil {
+			// the build Job ignores the command and is killed by activeDeadlineSeconds instead
+			timeout := strconv.FormatInt(timeoutSeconds(build.Timeout.Duration), 10)
+			buildContainer.Command = []string{"/bin/bash", "-c", `exec timeout --kill-after=` + buildKillAfter + ` "${BUILD_TIMEOUT}" /build/` + BuildScriptName + `.sh`}
+			buildContainer.Env = append(buildContainer.Env, corev1.EnvVar{
+				Name:  "BUILD_TIMEOUT",
+				Value: timeout,
+			})
+		}
+	}
 
+	buildContainer.Env = append(buildContainer.Env, makePreviewEnv(webSite)...)
+	buildContainer.Env = append(buildContainer.Env, makeBuildTemplateEnv(webSite)...)
 	for _, secret := range webSite.Spec.BuildSecrets {
 		buildContainer.Env = append(buildContainer.Env, corev1.EnvVar{
 			Name: secret.Key,
@@ -659,18 +1005,13 @@ func (r *WebSiteReconciler) makeNginxPodTemplate(ctx context.Context, webSite *w
 			},
 		})
 	}
-	for _, secret := range webSite.Spec.ImagePullSecrets {
-		newTemplate.Spec.ImagePullSecrets = append(newTemplate.Spec.ImagePullSecrets, secret)
-	}
-
-	newTemplate.Spec.InitContainers = append(newTemplate.Spec.InitContainers, buildContainer)
-	return &newTemplate, nil
+	return buildContainer
 }
 
 //go:embed nginx.conf
 var defaultNginxConf string
 
-func (r *WebSiteReconciler) reconcileNginxConfigMap(ctx context.Context, webSite *websitev1beta1.WebSite, source *websitev1beta1.DataSource) (bool, string, error) {
+func (r *WebSiteReconciler) reconcileNginxConfigMap(ctx context.Context, webSite *websitev1beta1.WebSite, source *websitev1beta1.DataSource, trusted []websitev1beta1.ConfigMapSource) (bool, string, error) {
 	log := r.log.WithValues("website", webSite.Name)
 
 	conf := ""
@@ -679,20 +1020,11 @@ func (r *WebSiteReconciler) reconcileNginxConfigMap(ctx context.Context, webSite
 	} else if source.RawData != nil {
 		conf = *source.RawData
 	} else if source.ConfigMap != nil {
-		nginxConfConfigMap := &corev1.ConfigMap{}
-		ns := r.operatorNamespace
-		if len((*source.ConfigMap).Namespace) != 0 {
-			ns = (*source.ConfigMap).Namespace
-		}
-		err := r.client.Get(ctx, client.ObjectKey{Namespace: ns, Name: (*source.ConfigMap).Name}, nginxConfConfigMap)
+		var err error
+		conf, err = r.readConfigMapSource(ctx, webSite, source.ConfigMap, trusted)
 		if err != nil {
 			return false, "", err
 		}
-		var ok bool
-		conf, ok = nginxConfConfigMap.Data[(*source.ConfigMap).Key]
-		if !ok {
-			return false, "", fmt.Errorf("ConfigMap %s:%s does not have %s", ns, (*source.ConfigMap).Name, (*source.ConfigMap).Key)
-		}
 	}
 
 	cm := &corev1.ConfigMap{}
@@ -757,10 +1089,21 @@ func (r *WebSiteReconciler) reconcileNginxService(ctx context.Context, webSite *
 		}
 		service.Spec.Ports = ports
 
+		color := ""
+		if isBlueGreen(webSite) {
+			color = webSite.Status.ActiveColor
+			if len(color) == 0 && service.Spec.Selector[ColorKey] == ColorRollingUpdate {
+				// keep selecting the pods of the RollingUpdate strategy until the first switch
+				color = ColorRollingUpdate
+			}
+		}
 		service.Spec.Selector = make(map[string]string)
 		service.Spec.Selector[ManagedByKey] = OperatorName
 		service.Spec.Selector[AppNameKey] = AppNameNginx
 		service.Spec.Selector[InstanceKey] = webSite.Name
+		if len(color) != 0 {
+			service.Spec.Selector[ColorKey] = color
+		}
 
 		return ctrl.SetControllerReference(webSite, service, r.scheme)
 	})
@@ -777,78 +1120,133 @@ func (r *WebSiteReconciler) reconcileNginxService(ctx context.Context, webSite *
 	return false, nil
 }
 
-func (r *WebSiteReconciler) reconcileExtraResources(ctx context.Context, webSite *websitev1beta1.WebSite) (bool, error) {
+func (r *WebSiteReconciler) reconcileExtraResources(ctx context.Context, webSite *websitev1beta1.WebSite, revision string) (bool, error) {
 	log := r.log.WithValues("website", webSite.Name)
 	isUpdated := false
 
+	var applied []websitev1beta1.ExtraResourceRef
 	for _, res := range webSite.Spec.ExtraResources {
-		extra, err := r.extraResource(ctx, webSite, &res)
+		extras, err := r.extraResources(ctx, webSite, &res, revision)
 		if err != nil {
 			return false, err
 		}
-		obj := extra.DeepCopy()
-		op, err := ctrl.CreateOrUpdate(ctx, r.client, obj, func() error {
-			if !equality.Semantic.DeepDerivative(extra, obj) {
-				obj = extra.DeepCopy()
+		for _, extra := range extras {
+			obj := extra.DeepCopy()
+			op, err := ctrl.CreateOrUpdate(ctx, r.client, obj, func() error {
+				if !equality.Semantic.DeepDerivative(extra, obj) {
+					rv := obj.GetResourceVersion()
+					extra.DeepCopyInto(obj)
+					obj.SetResourceVersion(rv)
+				}
+				return ctrl.SetControllerReference(webSite, obj, r.scheme)
+			})
+			if err != nil {
+				return false, err
+			}
+			if op != controllerutil.OperationResultNone {
+				log.Info("reconcile extraResource successfully", "op", op, "kind", obj.GetKind(), "name", obj.GetName())
+				isUpdated = true
+			}
+			if ref := extraResourceRef(extra); !slices.Contains(applied, ref) {
+				applied = append(applied, ref)
 			}
-			return ctrl.SetControllerReference(webSite, obj, r.scheme)
-		})
-		if err != nil {
-			return false, err
-		}
-		if op != controllerutil.OperationResultNone {
-			log.Info("reconcile extraResource successfully", "op", op)
-			isUpdated = true
 		}
 	}
 
+	// pruning only after all the resources are rendered, not to delete the ones that fail to be rendered temporarily
+	err := r.pruneExtraResources(ctx, webSite, applied)
+	if err != nil {
+		return false, err
+	}
 	return isUpdated, nil
 }
 
-func (r *WebSiteReconciler) extraResource(ctx context.Context, webSite *websitev1beta1.WebSite, res *websitev1beta1.DataSource) (*unstructured.Unstructured, error) {
+// extraResourceContext is the data given to the templates of ExtraResources.
+type extraResourceContext struct {
+	// ResourceName is the name of the WebSite
+	ResourceName string
+	// ResourceNamespace is the namespace of the WebSite
+	ResourceNamespace string
+	// Spec is the spec of the WebSite
+	Spec websitev1beta1.WebSiteSpec
+	// Revision is the revision being deployed
+	Revision string
+	// Host is the host of PublicURL
+	Host string
+	// Path is the path of PublicURL
+	Path string
+	// Labels are the labels of the WebSite
+	Labels map[string]string
+	// SelectorLabels are the labels to select the nginx pods
+	SelectorLabels map[string]string
+}
+
+// extraResources renders the template of the extra resource that may contain multiple YAML documents.
+func (r *WebSiteReconciler) extraResources(ctx context.Context, webSite *websitev1beta1.WebSite, res *websitev1beta1.DataSource, revision string) ([]*unstructured.Unstructured, error) {
 	resourceTemplate := ""
 	if res.RawData != nil {
 		resourceTemplate = *res.RawData
 	} else if res.ConfigMap != nil {
-		resourceTemplateConfigMap := &corev1.ConfigMap{}
-		ns := r.operatorNamespace
-		if len((*res.ConfigMap).Namespace) != 0 {
-			ns = (*res.ConfigMap).Namespace
-		}
-		err := r.client.Get(ctx, client.ObjectKey{Namespace: ns, Name: (*res.ConfigMap).Name}, resourceTemplateConfigMap)
+		var err error
+		resourceTemplate, err = r.readConfigMapSource(ctx, webSite, res.ConfigMap, nil)
 		if err != nil {
 			return nil, err
 		}
-		var ok bool
-		resourceTemplate, ok = resourceTemplateConfigMap.Data[(*res.ConfigMap).Key]
-		if !ok {
-			return nil, fmt.Errorf("ConfigMap %s:%s does not have %s", ns, (*res.ConfigMap).Name, (*res.ConfigMap).Key)
-		}
 	} else {
 		return nil, errors.New("extraResource should not be empty")
 	}
-	t, err := template.New("extra").Parse(resourceTemplate)
+	t, err := template.New("extra").Funcs(websitev1beta1.ExtraResourceFuncMap()).Parse(resourceTemplate)
 	if err != nil {
 		return nil, err
 	}
-	buf := bytes.NewBuffer(nil)
-	err = t.Execute(buf, struct {
-		ResourceName      string
-		ResourceNamespace string
-	}{
+
+	data := extraResourceContext{
 		ResourceName:      webSite.Name,
 		ResourceNamespace: webSite.Namespace,
-	})
-	if err != nil {
-		return nil, err
+		Spec:              webSite.Spec,
+		Revision:          revision,
+		Labels:            webSite.Labels,
+		SelectorLabels: map[string]string{
+			ManagedByKey: OperatorName,
+			AppNameKey:   AppNameNginx,
+			InstanceKey:  webSite.Name,
+		},
+	}
+	if len(webSite.Spec.PublicURL) != 0 {
+		data.Host, data.Path, err = publicHostAndPath(webSite)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var obj unstructured.Unstructured
-	err = yaml.Unmarshal(buf.Bytes(), &obj)
+	buf := bytes.NewBuffer(nil)
+	err = t.Execute(buf, data)
 	if err != nil {
 		return nil, err
 	}
-	obj.SetNamespace(webSite.Namespace)
-	return &obj, nil
+
+	var objs []*unstructured.Unstructured
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(buf))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		err = yaml.Unmarshal(doc, &obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		// skip the documents that have only comments or are emptied by conditions of the template
+		if len(obj.Object) == 0 {
+			continue
+		}
+		obj.SetNamespace(webSite.Namespace)
+		objs = append(objs, obj)
+	}
+	return objs, nil
 }
 
 var errJobIsActive = errors.New("job is active")
@@ -898,6 +1296,9 @@ func (r *WebSiteReconciler) reconcileAfterBuildScript(ctx context.Context, webSi
 			},
 		)
 	}
+	if webSite.Spec.GitAuth != nil {
+		template.Spec.Volumes = append(template.Spec.Volumes, makeGitAuthVolume(webSite))
+	}
 	template.Spec.SecurityContext = &corev1.PodSecurityContext{
 		FSGroup: ptr.To[int64](10000),
 	}
@@ -935,6 +1336,11 @@ func (r *WebSiteReconciler) reconcileAfterBuildScript(ctx context.Context, webSi
 			Name:      "deploy-key",
 		})
 	}
+	if webSite.Spec.GitAuth != nil {
+		buildContainer.VolumeMounts = append(buildContainer.VolumeMounts, makeGitAuthVolumeMount())
+		buildContainer.Env = append(buildContainer.Env, makeGitAuthEnv()...)
+	}
+	buildContainer.Env = append(buildContainer.Env, makeBuildTemplateEnv(webSite)...)
 	for _, secret := range webSite.Spec.BuildSecrets {
 		buildContainer.Env = append(buildContainer.Env, corev1.EnvVar{
 			Name: secret.Key,
@@ -948,6 +1354,9 @@ func (r *WebSiteReconciler) reconcileAfterBuildScript(ctx context.Context, webSi
 			},
 		})
 	}
+	if afterBuild := webSite.Spec.AfterBuild; afterBuild != nil {
+		buildContainer.Resources = afterBuild.Resources
+	}
 	for _, secret := range webSite.Spec.ImagePullSecrets {
 		template.Spec.ImagePullSecrets = append(template.Spec.ImagePullSecrets, secret)
 	}
@@ -974,6 +1383,10 @@ func (r *WebSiteReconciler) reconcileAfterBuildScript(ctx context.Context, webSi
 	newJob.SetNamespace(webSite.Namespace)
 	newJob.SetName(webSite.Name)
 	newJob.Spec.Template = template
+	if afterBuild := webSite.Spec.AfterBuild; afterBuild != nil {
+		newJob.Spec.BackoffLimit = afterBuild.BackoffLimit
+		newJob.Spec.ActiveDeadlineSeconds = afterBuild.ActiveDeadlineSeconds
+	}
 	err = ctrl.SetControllerReference(webSite, newJob, r.scheme)
 	if err != nil {
 		return false, err
@@ -997,9 +1410,13 @@ func setStandardLabels(app string, om *metav1.ObjectMeta) {
 	om.Labels[AppNameKey] = app
 }
 
-func selectReadyWebSite(obj client.Object) []string {
+func selectRevisionResolvedWebSite(obj client.Object) []string {
 	site := obj.(*websitev1beta1.WebSite)
-	return []string{string(site.Status.Ready)}
+	cond := meta.FindStatusCondition(site.Status.Conditions, websitev1beta1.ConditionRevisionResolved)
+	if cond == nil {
+		return []string{string(metav1.ConditionUnknown)}
+	}
+	return []string{string(cond.Status)}
 }
 
 func getVolumeOrEmptyDir(webSite *websitev1beta1.WebSite, name string) corev1.Volume {
@@ -1019,59 +1436,105 @@ func getVolumeOrEmptyDir(webSite *websitev1beta1.WebSite, name string) corev1.Vo
 // SetupWithManager sets up the controller with the Manager.
 func (r *WebSiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	ctx := context.Background()
-	err := mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.WebSite{}, website.WebSiteIndexField, selectReadyWebSite)
+	err := mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.WebSite{}, website.WebSiteIndexField, selectRevisionResolvedWebSite)
+	if err != nil {
+		return err
+	}
+
+	r.recorder = mgr.GetEventRecorderFor(OperatorName)
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
 	if err != nil {
 		return err
 	}
+	r.discovery = memory.NewMemCacheClient(dc)
 
 	ch := make(chan event.TypedGenericEvent[*websitev1beta1.WebSite])
-	watcher := newRevisionWatcher(mgr.GetClient(), mgr.GetLogger().WithName("RevisionWatcher"), ch, 1*time.Minute, r.revisionClient)
+	watcher := newRevisionWatcher(mgr.GetClient(), mgr.GetLogger().WithName("RevisionWatcher"), ch, r.watchInterval, r.revisionClient)
 	err = mgr.Add(watcher)
 	if err != nil {
 		return err
 	}
+	if len(r.notifyBindAddress) != 0 {
+		err = mgr.Add(newNotificationServer(mgr.GetClient(), r.apiReader, mgr.GetLogger().WithName("NotificationServer"), ch, mgr.Elected(), r.notifyBindAddress))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.WebSite{}, website.ConfigMapIndexField, func(obj client.Object) []string {
+		return r.referencedConfigMaps(obj.(*websitev1beta1.WebSite))
+	})
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.WebSite{}, website.SecretIndexField, func(obj client.Object) []string {
+		return referencedSecrets(obj.(*websitev1beta1.WebSite))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.WebSite{}, website.BuildTemplateIndexField, func(obj client.Object) []string {
+		return referencedBuildTemplate(obj.(*websitev1beta1.WebSite))
+	})
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(ctx, &websitev1beta1.BuildTemplate{}, website.ConfigMapIndexField, func(obj client.Object) []string {
+		tmpl := obj.(*websitev1beta1.BuildTemplate)
+		return r.configMapKeys([]*websitev1beta1.DataSource{tmpl.Spec.BuildScript, tmpl.Spec.AfterBuildScript, tmpl.Spec.NginxConf})
+	})
+	if err != nil {
+		return err
+	}
 
-	logger := mgr.GetLogger().WithName("ConfigMap Handler")
+	cmLogger := mgr.GetLogger().WithName("ConfigMap Handler")
+	webSiteCMHandler := r.referenceHandler(cmLogger, website.ConfigMapIndexField)
+	templateCMHandler := r.templateConfigMapHandler(cmLogger)
 	cmHandler := func(ctx context.Context, o client.Object) []reconcile.Request {
-		wsl := &websitev1beta1.WebSiteList{}
-		err := r.client.List(ctx, wsl)
-		if err != nil {
-			logger.Error(err, "failed to list WebSites")
+		return append(webSiteCMHandler(ctx, o), templateCMHandler(ctx, o)...)
+	}
+	templateHandler := r.referenceHandler(mgr.GetLogger().WithName("BuildTemplate Handler"), website.BuildTemplateIndexField)
+	secretHandler := r.referenceHandler(mgr.GetLogger().WithName("Secret Handler"), website.SecretIndexField)
+
+	podHandler := func(ctx context.Context, o client.Object) []reconcile.Request {
+		labels := o.GetLabels()
+		if labels[ManagedByKey] != OperatorName || labels[AppNameKey] != AppNameNginx || len(labels[InstanceKey]) == 0 {
 			return nil
 		}
-		var requests []reconcile.Request
-		for _, ws := range wsl.Items {
-			if ws.Spec.BuildScript.ConfigMap != nil && ws.Spec.BuildScript.ConfigMap.Name == o.GetName() {
-				requests = append(requests, reconcile.Request{
-					NamespacedName: types.NamespacedName{
-						Namespace: ws.GetNamespace(),
-						Name:      ws.GetName(),
-					},
-				})
-				continue
-			}
-			if ws.Spec.AfterBuildScript != nil &&
-				ws.Spec.AfterBuildScript.ConfigMap != nil &&
-				ws.Spec.AfterBuildScript.ConfigMap.Name == o.GetName() {
-				requests = append(requests, reconcile.Request{
-					NamespacedName: types.NamespacedName{
-						Namespace: ws.GetNamespace(),
-						Name:      ws.GetName(),
-					},
-				})
-				continue
-			}
+		return []reconcile.Request{
+			{
+				NamespacedName: types.NamespacedName{
+					Namespace: o.GetNamespace(),
+					Name:      labels[InstanceKey],
+				},
+			},
 		}
-		return requests
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&websitev1beta1.WebSite{}).
 		Owns(&corev1.Service{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&batchv1.Job{}).
+		Owns(&networkingv1.Ingress{}).
 		WatchesRawSource(source.Channel(ch, &handler.TypedEnqueueRequestForObject[*websitev1beta1.WebSite]{})).
 		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(cmHandler)).
-		Complete(r)
+		// only the names of Secrets are needed to find the WebSites referring to them
+		WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(secretHandler)).
+		Watches(&websitev1beta1.BuildTemplate{}, handler.EnqueueRequestsFromMapFunc(templateHandler)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podHandler))
+
+	// HTTPRoute is watched only if the Gateway API is installed
+	_, err = mgr.GetRESTMapper().RESTMapping(httpRouteGVK.GroupKind(), httpRouteGVK.Version)
+	switch {
+	case err == nil:
+		b = b.Owns(newHTTPRoute())
+	case meta.IsNoMatchError(err):
+		mgr.GetLogger().Info("HTTPRoute is not watched because the Gateway API is not installed")
+	default:
+		return err
+	}
+	return b.Complete(r)
 }
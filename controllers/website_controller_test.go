@@ -2,18 +2,36 @@ package controllers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cybozu-go/website-operator"
 	websitev1beta1 "github.com/cybozu-go/website-operator/api/v1beta1"
+	"github.com/cybozu-go/website-operator/checker"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -26,8 +44,20 @@ var _ = Describe("WebSite controller", func() {
 	ctx := context.Background()
 	var stopFunc func()
 	var mockClient mockRevisionClient
+	configMapPolicy, _ := ParseConfigMapPolicy([]string{"*=website-operator-system"})
+	// gitHubAPI is the allowed GitHub API, which serves the requests by gitHubAPIHandler
+	var gitHubAPI *httptest.Server
+	var gitHubAPIHandler http.HandlerFunc
 
 	BeforeEach(func() {
+		gitHubAPIHandler = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+		gitHubAPI = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gitHubAPIHandler(w, r)
+		}))
+		DeferCleanup(gitHubAPI.Close)
+
 		err := k8sClient.DeleteAllOf(ctx, &websitev1beta1.WebSite{}, client.InNamespace("test"))
 		Expect(err).NotTo(HaveOccurred())
 		err = k8sClient.DeleteAllOf(ctx, &corev1.ConfigMap{}, client.InNamespace("test"))
@@ -36,6 +66,10 @@ var _ = Describe("WebSite controller", func() {
 		Expect(err).NotTo(HaveOccurred())
 		err = k8sClient.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace("test"), client.PropagationPolicy(metav1.DeletePropagationBackground))
 		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.DeleteAllOf(ctx, &networkingv1.Ingress{}, client.InNamespace("test"))
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.DeleteAllOf(ctx, &corev1.Secret{}, client.InNamespace("test"))
+		Expect(err).NotTo(HaveOccurred())
 		svcs := &corev1.ServiceList{}
 		err = k8sClient.List(ctx, svcs, client.InNamespace("test"))
 		Expect(err).NotTo(HaveOccurred())
@@ -53,8 +87,9 @@ var _ = Describe("WebSite controller", func() {
 		})
 		Expect(err).ToNot(HaveOccurred())
 
-		mockClient = mockRevisionClient{"rev1"}
+		mockClient = mockRevisionClient{rev: "rev1"}
 		err = NewWebSiteReconciler(
+			k8sClient,
 			k8sClient,
 			ctrl.Log.WithName("controllers").WithName("WebSite"),
 			scheme,
@@ -62,6 +97,11 @@ var _ = Describe("WebSite controller", func() {
 			website.DefaultRepoCheckerContainerImage,
 			"website-operator-system",
 			&mockClient,
+			"",
+			"http://website-operator-notification.website-operator-system.svc",
+			time.Minute,
+			configMapPolicy,
+			[]string{gitHubAPI.URL},
 		).SetupWithManager(mgr)
 		Expect(err).NotTo(HaveOccurred())
 
@@ -122,6 +162,20 @@ cp -r _book/* $OUTPUT/
 				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-script"}, &cm)
 			}).Should(Succeed())
 			Expect(cm.Data).Should(HaveKey("build.sh"))
+
+			bsCm.Data = map[string]string{
+				"script": buildScript + "echo done\n",
+			}
+			err = k8sClient.Update(ctx, bsCm)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-script"}, &cm)
+				if err != nil {
+					return ""
+				}
+				return cm.Data["build.sh"]
+			}).Should(HaveSuffix("echo done\n"))
 		})
 	})
 
@@ -156,9 +210,23 @@ cp -r _book/* $OUTPUT/
 			Expect(dep.Spec.Template.Spec.Containers).Should(HaveLen(1))
 			Expect(dep.Spec.Template.Spec.Containers[0].Name).Should(Equal("repo-checker"))
 			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--repo-branch=main"))
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--ssh-key-file=/home/ubuntu/.ssh/id_rsa"))
 			Expect(dep.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
 			Expect(dep.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
 			Expect(dep.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).ShouldNot(ContainElement("--insecure-ignore-host-key"))
+
+			By("skipping the verification of the host key")
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(site), site)
+			Expect(err).NotTo(HaveOccurred())
+			site.Spec.InsecureIgnoreHostKey = true
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--insecure-ignore-host-key"))
+			}).Should(Succeed())
 		})
 
 		It("should create RepoChecker Deployment with ImagePullSecrets", func() {
@@ -179,6 +247,98 @@ cp -r _book/* $OUTPUT/
 			Expect(dep.Spec.Template.Spec.ImagePullSecrets).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("myimagepullsecret")})))
 		})
 
+		It("should create RepoChecker Deployment with Tag", func() {
+			site := newWebSite().withRawBuildScript().withTag(">=2.0.0 <3").build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+
+			Expect(dep.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--repo-tag=>=2.0.0 <3"))
+		})
+
+		It("should create RepoChecker Deployment with PollInterval", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.PollInterval = &metav1.Duration{Duration: 30 * time.Second}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--interval=30s"))
+		})
+
+		It("should not let RepoChecker poll more often than MinPollInterval", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.PollInterval = &metav1.Duration{Duration: time.Second}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--interval=10s"))
+		})
+
+		It("should create RepoChecker Deployment with WebhookSecret", func() {
+			site := newWebSite().withRawBuildScript().withWebhookSecret().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+
+			Expect(dep.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--notify-url=http://website-operator-notification.website-operator-system.svc/notify/test/mysite"))
+			Expect(dep.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name": Equal("WEBHOOK_SECRET"),
+				"ValueFrom": PointTo(MatchFields(IgnoreExtras, Fields{
+					"SecretKeyRef": PointTo(MatchFields(IgnoreExtras, Fields{"Key": Equal("token")})),
+				})),
+			})))
+		})
+
+		It("should restart RepoChecker when the webhook secret is rotated", func() {
+			secret := &corev1.Secret{}
+			secret.Namespace = "test"
+			secret.Name = "mywebhooksecret"
+			secret.Data = map[string][]byte{"token": []byte("old")}
+			err := k8sClient.Create(ctx, secret)
+			Expect(err).NotTo(HaveOccurred())
+
+			site := newWebSite().withRawBuildScript().withWebhookSecret().build()
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+			checksum := dep.Spec.Template.Annotations[AnnChecksumWebhookSecret]
+			Expect(checksum).ShouldNot(BeEmpty())
+
+			secret.Data = map[string][]byte{"token": []byte("new")}
+			err = k8sClient.Update(ctx, secret)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+				if err != nil {
+					return ""
+				}
+				return dep.Spec.Template.Annotations[AnnChecksumWebhookSecret]
+			}).ShouldNot(Or(BeEmpty(), Equal(checksum)))
+		})
+
 		It("should create RepoChecker Service", func() {
 			site := newWebSite().withRawBuildScript().build()
 			err := k8sClient.Create(ctx, site)
@@ -189,6 +349,50 @@ cp -r _book/* $OUTPUT/
 				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &svc)
 			}).Should(Succeed())
 		})
+
+		It("should not create RepoChecker when the shared repo-checker is used", func() {
+			mockClient.shared = true
+			site := newWebSite().withRawBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+			}).Should(Succeed())
+			Consistently(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+				return apierrors.IsNotFound(err)
+			}, 2*time.Second).Should(BeTrue())
+		})
+
+		It("should delete RepoChecker when the site switches to the shared repo-checker", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.DedicatedRepoChecker = true
+			mockClient.shared = true
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+
+			Eventually(func() error {
+				current := &websitev1beta1.WebSite{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, current)
+				if err != nil {
+					return err
+				}
+				current.Spec.DedicatedRepoChecker = false
+				return k8sClient.Update(ctx, current)
+			}).Should(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+				return apierrors.IsNotFound(err)
+			}).Should(BeTrue())
+		})
 	})
 
 	Context("Nginx", func() {
@@ -283,6 +487,38 @@ cp -r _book/* $OUTPUT/
 			Expect(dep.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
 		})
 
+		It("should roll out Nginx Deployment when the build secret is rotated", func() {
+			secret := &corev1.Secret{}
+			secret.Namespace = "test"
+			secret.Name = "mybuildsecret"
+			secret.Data = map[string][]byte{"VAR_KEY": []byte("old")}
+			err := k8sClient.Create(ctx, secret)
+			Expect(err).NotTo(HaveOccurred())
+
+			site := newWebSite().withRawBuildScript().withBuildSecrets().build()
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+			}).Should(Succeed())
+			checksum := dep.Spec.Template.Annotations[AnnChecksumConfig]
+			Expect(checksum).ShouldNot(BeEmpty())
+
+			secret.Data = map[string][]byte{"VAR_KEY": []byte("new")}
+			err = k8sClient.Update(ctx, secret)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+				if err != nil {
+					return ""
+				}
+				return dep.Spec.Template.Annotations[AnnChecksumConfig]
+			}).ShouldNot(Or(BeEmpty(), Equal(checksum)))
+		})
+
 		It("should create Nginx Deployment with Replicas", func() {
 			site := newWebSite().withRawBuildScript().withReplicas(3).build()
 			err := k8sClient.Create(ctx, site)
@@ -305,6 +541,27 @@ cp -r _book/* $OUTPUT/
 			Expect(dep.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
 		})
 
+		It("should create Nginx Deployment with the resources and the timeout of the build", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.Build = &websitev1beta1.BuildSpec{
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+				},
+				Timeout: &metav1.Duration{Duration: 10 * time.Minute},
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+			}).Should(Succeed())
+			build := dep.Spec.Template.Spec.InitContainers[0]
+			Expect(build.Resources.Limits.Memory().String()).Should(Equal("2Gi"))
+			Expect(build.Command[2]).Should(ContainSubstring("timeout"))
+			Expect(build.Env).Should(ContainElement(corev1.EnvVar{Name: "BUILD_TIMEOUT", Value: "600"}))
+		})
+
 		It("should create Nginx Deployment with PodTemplate", func() {
 			site := newWebSite().withRawBuildScript().withPodTemplate().build()
 			err := k8sClient.Create(ctx, site)
@@ -358,142 +615,1531 @@ cp -r _book/* $OUTPUT/
 		})
 	})
 
-	Context("ExtraResources", func() {
-		It("should create extraResources", func() {
-			site := newWebSite().withRawBuildScript().withExtraResources().build()
+	Context("Route", func() {
+		It("should create Ingress from PublicURL", func() {
+			site := newWebSite().withRawBuildScript().withIngress("https://docs.example.com/mysite").build()
 			err := k8sClient.Create(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			cm := corev1.ConfigMap{}
-			cm.Namespace = site.Namespace
-			cm.Name = "my-templates"
-			cm.Data = map[string]string{
-				"ubuntu": `apiVersion: v1
-kind: Pod
-metadata:
-  name: {{.ResourceName}}-ubuntu
-  namespace: unknown
-spec:
-  containers:
-  - name: ubuntu
-    image: ghcr.io/zoetrope/ubuntu:22.04
-    command: ["/usr/local/bin/pause"]
-`,
-			}
-			err = k8sClient.Create(ctx, &cm)
-			Expect(err).ShouldNot(HaveOccurred())
+			ingress := networkingv1.Ingress{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &ingress)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			Expect(ingress.Spec.IngressClassName).Should(Equal(ptr.To("nginx")))
+			Expect(ingress.Spec.Rules).Should(HaveLen(1))
+			Expect(ingress.Spec.Rules[0].Host).Should(Equal("docs.example.com"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Path).Should(Equal("/mysite"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name).Should(Equal("mysite"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number).Should(Equal(int32(NginxPort)))
+			Expect(ingress.Spec.TLS).Should(ConsistOf(networkingv1.IngressTLS{Hosts: []string{"docs.example.com"}, SecretName: "mysite-tls"}))
+
+			By("reporting the admitted address")
+			ingress.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.0.1"}}
+			err = k8sClient.Status().Update(ctx, &ingress)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Address).Should(Equal("10.0.0.1"))
+			}).Should(Succeed())
 
-			pod := corev1.Pod{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: site.Namespace, Name: site.Name + "-ubuntu"}, &pod)
+			By("removing the ingress")
+			site.Spec.Ingress = nil
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &ingress)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
 			}).Should(Succeed())
 		})
-	})
 
-	Context("AfterBuildcript", func() {
-		It("should create afterBuildScript job", func() {
-			site := newWebSite().withRawBuildScript().withAfterBuildScript().build()
+		It("should remove the annotations removed from the WebSite", func() {
+			site := newWebSite().withRawBuildScript().withIngress("https://docs.example.com/mysite").build()
+			site.Spec.Ingress.Annotations = map[string]string{
+				"example.com/foo": "foo",
+				"example.com/bar": "bar",
+			}
 			err := k8sClient.Create(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			job := batchv1.Job{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			ingress := networkingv1.Ingress{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &ingress)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ingress.Annotations).Should(HaveKeyWithValue("example.com/foo", "foo"))
+				g.Expect(ingress.Annotations).Should(HaveKeyWithValue("example.com/bar", "bar"))
 			}).Should(Succeed())
 
-			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
-			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
-			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
-			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
-			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
-			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
-		})
+			By("adding an annotation by another controller")
+			ingress.Annotations["example.com/other"] = "other"
+			err = k8sClient.Update(ctx, &ingress)
+			Expect(err).NotTo(HaveOccurred())
 
-		It("should create afterBuildscript job with deploy key", func() {
-			site := newWebSite().withRawBuildScript().withDeployKey().withAfterBuildScript().build()
-			err := k8sClient.Create(ctx, site)
+			By("removing an annotation from the WebSite")
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+			Expect(err).NotTo(HaveOccurred())
+			site.Spec.Ingress.Annotations = map[string]string{
+				"example.com/bar": "baz",
+			}
+			err = k8sClient.Update(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			job := batchv1.Job{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &ingress)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ingress.Annotations).ShouldNot(HaveKey("example.com/foo"))
+				g.Expect(ingress.Annotations).Should(HaveKeyWithValue("example.com/bar", "baz"))
+				g.Expect(ingress.Annotations).Should(HaveKeyWithValue("example.com/other", "other"))
 			}).Should(Succeed())
+		})
 
-			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
-			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
-			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
-			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
-			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
-			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+		It("should remove the annotations of HTTPRoute removed from the WebSite", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.PublicURL = "https://docs.example.com/mysite"
+			site.Spec.HTTPRoute = &websitev1beta1.HTTPRouteSpec{
+				ParentRefs: []websitev1beta1.ParentReference{{Name: "gateway"}},
+				Annotations: map[string]string{
+					"example.com/foo": "foo",
+					"example.com/bar": "bar",
+				},
+			}
+			r := &WebSiteReconciler{scheme: scheme}
+			route := newHTTPRoute()
+			err := r.updateHTTPRoute(site, route, "docs.example.com", "/mysite")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(route.GetAnnotations()).Should(HaveKeyWithValue("example.com/foo", "foo"))
+			Expect(route.GetAnnotations()).Should(HaveKeyWithValue("example.com/bar", "bar"))
+
+			By("adding an annotation by another controller")
+			annotations := route.GetAnnotations()
+			annotations["example.com/other"] = "other"
+			route.SetAnnotations(annotations)
+
+			By("removing an annotation from the WebSite")
+			site.Spec.HTTPRoute.Annotations = map[string]string{
+				"example.com/bar": "baz",
+			}
+			err = r.updateHTTPRoute(site, route, "docs.example.com", "/mysite")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(route.GetAnnotations()).ShouldNot(HaveKey("example.com/foo"))
+			Expect(route.GetAnnotations()).Should(HaveKeyWithValue("example.com/bar", "baz"))
+			Expect(route.GetAnnotations()).Should(HaveKeyWithValue("example.com/other", "other"))
+
+			By("removing all the annotations from the WebSite")
+			site.Spec.HTTPRoute.Annotations = nil
+			err = r.updateHTTPRoute(site, route, "docs.example.com", "/mysite")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(route.GetAnnotations()).Should(Equal(map[string]string{"example.com/other": "other"}))
 		})
+	})
 
-		It("should create afterBuildScirpt job with Image Secrets", func() {
-			site := newWebSite().withRawBuildScript().withImagePullSecrets().withAfterBuildScript().build()
+	Context("Status", func() {
+		It("should report conditions", func() {
+			site := newWebSite().withRawBuildScript().build()
 			err := k8sClient.Create(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			job := batchv1.Job{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.ObservedGeneration).Should(Equal(site.Generation))
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+				g.Expect(site.Status.Conditions).Should(ContainElements(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionRevisionResolved), "Status": Equal(metav1.ConditionTrue)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionConfigRendered), "Status": Equal(metav1.ConditionTrue)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionExtraResourcesApplied), "Status": Equal(metav1.ConditionTrue)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionAfterBuildCompleted), "Status": Equal(metav1.ConditionTrue), "Reason": Equal(ReasonNotConfigured)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionNginxAvailable), "Status": Equal(metav1.ConditionFalse)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionBuildSucceeded), "Status": Equal(metav1.ConditionUnknown), "Reason": Equal(ReasonBuilding)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionReady), "Status": Equal(metav1.ConditionFalse)}),
+				))
 			}).Should(Succeed())
-
-			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
-			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
-			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
-			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
-			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("VAR_KEY")})))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
-			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("myimagepullsecret")})))
 		})
 
-		It("should create afterBuildscript job with Build Secrets", func() {
-			site := newWebSite().withRawBuildScript().withBuildSecrets().withAfterBuildScript().build()
+		It("should deploy the pinned revision and record the history", func() {
+			site := newWebSite().withRawBuildScript().build()
 			err := k8sClient.Create(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			job := batchv1.Job{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+			}).Should(Succeed())
+			Expect(site.Status.History).Should(BeEmpty())
+
+			By("recording the revision after it is served")
+			markDeploymentAvailable("mysite")
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.History).Should(HaveLen(1))
+				g.Expect(site.Status.History[0].Revision).Should(Equal("rev1"))
 			}).Should(Succeed())
 
-			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
-			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
-			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
-			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
-			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("VAR_KEY"), "ValueFrom": Equal(&corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mybuildsecret"}, Key: "VAR_KEY"}})})))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
-			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
-			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+			By("pinning the revision")
+			site.Spec.Revision = "rev0"
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(dep.Spec.Template.Spec.InitContainers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev0")})))
+			}).Should(Succeed())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Revision).Should(Equal("rev0"))
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionRevisionResolved), "Status": Equal(metav1.ConditionTrue), "Reason": Equal(ReasonPinned)}),
+				))
+			}).Should(Succeed())
+			Expect(site.Status.History).Should(HaveLen(1))
+
+			markDeploymentAvailable("mysite")
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.History).Should(HaveLen(2))
+				g.Expect(site.Status.History[0].Revision).Should(Equal("rev0"))
+				g.Expect(site.Status.History[1].Revision).Should(Equal("rev1"))
+			}).Should(Succeed())
 		})
 
-		It("should recreate afterBuildscript job when job exists and revision is updated ", func() {
-			site := newWebSite().withRawBuildScript().withAfterBuildScript().build()
+		It("should export the served revision as a metric", func() {
+			revisionDeploySeconds.DeleteLabelValues("test", "mysite")
+			site := newWebSite().withRawBuildScript().build()
 			err := k8sClient.Create(ctx, site)
 			Expect(err).NotTo(HaveOccurred())
 
-			job := batchv1.Job{}
-			Eventually(func() error {
-				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+				g.Expect(testutil.ToFloat64(revisionInfo.WithLabelValues("test", "mysite", "rev1"))).Should(Equal(1.0))
 			}).Should(Succeed())
 
-			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
-			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
-			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
-			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
-			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
-			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
-			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			By("observing the deploy time after the Deployment becomes available")
+			deploySeconds := func() *dto.Histogram {
+				m := &dto.Metric{}
+				err := revisionDeploySeconds.WithLabelValues("test", "mysite").(prometheus.Histogram).Write(m)
+				Expect(err).NotTo(HaveOccurred())
+				return m.GetHistogram()
+			}
+			Consistently(func() uint64 {
+				return deploySeconds().GetSampleCount()
+			}, 1).Should(BeZero())
+
+			markDeploymentAvailable("mysite")
+
+			Eventually(func(g Gomega) {
+				h := deploySeconds()
+				g.Expect(h.GetSampleCount()).Should(Equal(uint64(1)))
+				g.Expect(h.GetSampleSum()).Should(BeNumerically(">=", 1))
+			}).Should(Succeed())
+		})
+
+		It("should report the build killed for timing out", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysite-0"},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{Name: BuildScriptName, Env: []corev1.EnvVar{{Name: "BUILD_TIMEOUT", Value: "600"}}},
+					},
+				},
+				Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{
+						{
+							Name: BuildScriptName,
+							State: corev1.ContainerState{
+								Terminated: &corev1.ContainerStateTerminated{ExitCode: timeoutExitCode, Reason: "Error"},
+							},
+						},
+					},
+				},
+			}
+			reason, msg := buildFailure(pod)
+			Expect(reason).Should(Equal(ReasonBuildTimedOut))
+			Expect(msg).Should(ContainSubstring("timed out after 600s"))
+
+			pod.Status.InitContainerStatuses[0].State.Terminated = &corev1.ContainerStateTerminated{ExitCode: timeoutKilledExitCode, Reason: "OOMKilled"}
+			reason, _ = buildFailure(pod)
+			Expect(reason).Should(Equal(ReasonBuildFailed))
+		})
+
+		It("should report ConfigRendered condition when the build script is missing", func() {
+			site := newWebSite().withConfigMapBuildScript().build()
+			site.Spec.BuildScript.ConfigMap.Name = "missing"
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElements(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionConfigRendered), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonBuildScriptError)}),
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionReady), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonBuildScriptError)}),
+				))
+			}).Should(Succeed())
+		})
+	})
+
+	Context("ConfigMapPolicy", func() {
+		// reconcileOnce reconciles the WebSite by another reconciler to inspect the result.
+		reconcileOnce := func(site *websitev1beta1.WebSite) (ctrl.Result, error) {
+			r := NewWebSiteReconciler(
+				k8sClient,
+				k8sClient,
+				ctrl.Log.WithName("controllers").WithName("WebSite"),
+				scheme,
+				website.DefaultNginxContainerImage,
+				website.DefaultRepoCheckerContainerImage,
+				"website-operator-system",
+				&mockClient,
+				"",
+				"http://website-operator-notification.website-operator-system.svc",
+				time.Minute,
+				configMapPolicy,
+				nil,
+			)
+			r.recorder = record.NewFakeRecorder(100)
+			return r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(site)})
+		}
+
+		It("should not requeue the WebSite whose build script is not allowed", func() {
+			site := newWebSite().withConfigMapBuildScript().build()
+			site.Spec.BuildScript.ConfigMap.Namespace = "default"
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionConfigRendered), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonConfigMapNotAllowed)}),
+				))
+			}).Should(Succeed())
+
+			res, err := reconcileOnce(site)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).Should(Equal(ctrl.Result{}))
+		})
+
+		It("should not requeue the WebSite whose extra resources are not allowed", func() {
+			site := newWebSite().withRawBuildScript().withExtraResources().build()
+			site.Spec.ExtraResources[0].ConfigMap.Namespace = "default"
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionExtraResourcesApplied), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonConfigMapNotAllowed)}),
+				))
+			}).Should(Succeed())
+
+			res, err := reconcileOnce(site)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).Should(Equal(ctrl.Result{}))
+		})
+
+		It("should trust the ConfigMaps given by BuildTemplate", func() {
+			cm := &corev1.ConfigMap{}
+			cm.Namespace = "default"
+			cm.Name = "template-script"
+			cm.Data = map[string]string{"script": "#!/bin/bash\nnpm run build\n"}
+			err := k8sClient.Create(ctx, cm)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, cm)).To(Succeed())
+			})
+
+			tmpl := &websitev1beta1.BuildTemplate{}
+			tmpl.Name = "npm-trusted"
+			tmpl.Spec = websitev1beta1.BuildTemplateSpec{
+				BuildImage: "ghcr.io/zoetrope/node:22.16.0",
+				BuildScript: &websitev1beta1.DataSource{
+					ConfigMap: &websitev1beta1.ConfigMapSource{Namespace: "default", Name: "template-script", Key: "script"},
+				},
+			}
+			err = k8sClient.Create(ctx, tmpl)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, tmpl)).To(Succeed())
+			})
+
+			site := newWebSite().build()
+			site.Spec.BuildImage = ""
+			site.Spec.BuildTemplate = &websitev1beta1.BuildTemplateRef{Name: "npm-trusted"}
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			script := corev1.ConfigMap{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-script"}, &script)
+			}).Should(Succeed())
+			Expect(script.Data["build.sh"]).Should(ContainSubstring("npm run build"))
+
+			By("referring to the same namespace from the WebSite")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(site), site)).To(Succeed())
+			site.Spec.AfterBuildScript = &websitev1beta1.DataSource{
+				ConfigMap: &websitev1beta1.ConfigMapSource{Namespace: "default", Name: "template-script", Key: "other"},
+			}
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(site), site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionConfigRendered), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonConfigMapNotAllowed)}),
+				))
+			}).Should(Succeed())
+		})
+	})
+
+	Context("Events", func() {
+		It("should record events on the WebSite", func() {
+			site := newWebSite().withConfigMapBuildScript().build()
+			site.Spec.BuildScript.ConfigMap.Name = "missing"
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				events := &corev1.EventList{}
+				err := k8sClient.List(ctx, events, client.InNamespace("test"))
+				g.Expect(err).NotTo(HaveOccurred())
+				var reasons []string
+				for _, ev := range events.Items {
+					if ev.InvolvedObject.UID == site.UID {
+						reasons = append(reasons, ev.Reason)
+					}
+				}
+				g.Expect(reasons).Should(ContainElements(EventConfigMapMissing, EventConfigRenderFailed))
+			}).Should(Succeed())
+
+			By("fixing the build script")
+			site.Spec.BuildScript = websitev1beta1.DataSource{RawData: ptr.To("#!/bin/bash\n")}
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				events := &corev1.EventList{}
+				err := k8sClient.List(ctx, events, client.InNamespace("test"))
+				g.Expect(err).NotTo(HaveOccurred())
+				var reasons []string
+				for _, ev := range events.Items {
+					if ev.InvolvedObject.UID == site.UID {
+						reasons = append(reasons, ev.Reason)
+					}
+				}
+				g.Expect(reasons).Should(ContainElements(EventRevisionDetected, EventBuildStarted))
+			}).Should(Succeed())
+		})
+
+		It("should record the missing ConfigMap only once", func() {
+			recorder := record.NewFakeRecorder(10)
+			r := &WebSiteReconciler{recorder: recorder}
+			site := newWebSite().build()
+			missing := apierrors.NewNotFound(corev1.Resource("configmaps"), "missing")
+
+			r.recordConfigMapError(site, websitev1beta1.ConditionConfigRendered, missing)
+			setCondition(site, websitev1beta1.ConditionConfigRendered, metav1.ConditionFalse, ReasonBuildScriptError, missing.Error())
+			Expect(recorder.Events).Should(Receive(ContainSubstring(EventConfigMapMissing)))
+
+			By("retrying the reconciliation")
+			r.recordConfigMapError(site, websitev1beta1.ConditionConfigRendered, missing)
+			Expect(recorder.Events).ShouldNot(Receive())
+
+			By("missing another ConfigMap")
+			r.recordConfigMapError(site, websitev1beta1.ConditionConfigRendered, apierrors.NewNotFound(corev1.Resource("configmaps"), "another"))
+			Expect(recorder.Events).Should(Receive(ContainSubstring(EventConfigMapMissing)))
+		})
+	})
+
+	Context("GitAuth", func() {
+		It("should pass the credentials in the secret to repo-checker and the build", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.GitAuth = &websitev1beta1.GitAuth{SecretName: ptr.To("git-token")}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+			Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(ContainElement("--credentials-dir=/etc/git-auth"))
+			Expect(dep.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name": Equal("git-auth"),
+				"VolumeSource": MatchFields(IgnoreExtras, Fields{
+					"Secret": PointTo(MatchFields(IgnoreExtras, Fields{"SecretName": Equal("git-token")})),
+				}),
+			})))
+
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+			}).Should(Succeed())
+			Expect(dep.Spec.Template.Spec.InitContainers).Should(HaveLen(1))
+			build := dep.Spec.Template.Spec.InitContainers[0]
+			Expect(build.VolumeMounts).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"MountPath": Equal("/etc/git-auth")})))
+			Expect(build.Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name":  Equal("GIT_CONFIG_KEY_0"),
+				"Value": Equal("credential.helper"),
+			})))
+		})
+
+		It("should mint the installation token of the GitHub App", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			keySecret := &corev1.Secret{}
+			keySecret.Namespace = "test"
+			keySecret.Name = "github-app"
+			keySecret.Data = map[string][]byte{
+				GitHubAppPrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			}
+			err = k8sClient.Create(ctx, keySecret)
+			Expect(err).NotTo(HaveOccurred())
+
+			expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			gitHubAPIHandler = func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/app/installations/2/access_tokens" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+					http.Error(w, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token":"ghs_test","expires_at":%q}`, expiresAt.Format(time.RFC3339))
+			}
+
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.GitAuth = &websitev1beta1.GitAuth{
+				GitHubApp: &websitev1beta1.GitHubAppAuth{
+					AppID:                1,
+					InstallationID:       2,
+					PrivateKeySecretName: "github-app",
+					APIURL:               gitHubAPI.URL + "/",
+				},
+			}
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			secret := corev1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-git-auth"}, &secret)
+			}).Should(Succeed())
+			Expect(secret.Data).Should(HaveKeyWithValue("username", []byte("x-access-token")))
+			Expect(secret.Data).Should(HaveKeyWithValue("password", []byte("ghs_test")))
+			Expect(secret.Annotations).Should(HaveKeyWithValue(AnnTokenExpiresAt, expiresAt.Format(time.RFC3339)))
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-repo-checker"}, &dep)
+			}).Should(Succeed())
+			Expect(dep.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name": Equal("git-auth"),
+				"VolumeSource": MatchFields(IgnoreExtras, Fields{
+					"Secret": PointTo(MatchFields(IgnoreExtras, Fields{"SecretName": Equal("mysite-git-auth")})),
+				}),
+			})))
+		})
+
+		It("should not send the JWT to the GitHub API that is not allowed", func() {
+			var requested atomic.Bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested.Store(true)
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+			}))
+			defer server.Close()
+
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			keySecret := &corev1.Secret{}
+			keySecret.Namespace = "test"
+			keySecret.Name = "github-app"
+			keySecret.Data = map[string][]byte{
+				GitHubAppPrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			}
+			err = k8sClient.Create(ctx, keySecret)
+			Expect(err).NotTo(HaveOccurred())
+
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.GitAuth = &websitev1beta1.GitAuth{
+				GitHubApp: &websitev1beta1.GitHubAppAuth{
+					AppID:                1,
+					InstallationID:       2,
+					PrivateKeySecretName: "github-app",
+					APIURL:               server.URL,
+				},
+			}
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(site), site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+					"Type":    Equal(websitev1beta1.ConditionRevisionResolved),
+					"Status":  Equal(metav1.ConditionFalse),
+					"Reason":  Equal(ReasonGitAuthError),
+					"Message": ContainSubstring("not allowed"),
+				})))
+			}).Should(Succeed())
+			Expect(requested.Load()).Should(BeFalse())
+		})
+	})
+
+	Context("BuildCache", func() {
+		It("should mount the build cache to the build Job and purge it on demand", func() {
+			site := newWebSite().withRawBuildScript().withArtifacts().build()
+			site.Annotations = map[string]string{AnnPurgeBuildCache: "1"}
+			site.Spec.BuildCache = &websitev1beta1.BuildCacheStorage{
+				Size: resource.MustParse("5Gi"),
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc := corev1.PersistentVolumeClaim{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-cache"}, &pvc)
+			}).Should(Succeed())
+			Expect(pvc.Spec.AccessModes).Should(ConsistOf(corev1.ReadWriteMany))
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).Should(Equal("5Gi"))
+
+			jobs := batchv1.JobList{}
+			Eventually(func() ([]batchv1.Job, error) {
+				err := k8sClient.List(ctx, &jobs, client.InNamespace("test"), client.MatchingLabels{AppNameKey: AppNameBuild, InstanceKey: "mysite"})
+				return jobs.Items, err
+			}).Should(HaveLen(1))
+			podSpec := jobs.Items[0].Spec.Template.Spec
+			Expect(podSpec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"Name": Equal("build-cache"),
+				"VolumeSource": MatchFields(IgnoreExtras, Fields{
+					"PersistentVolumeClaim": PointTo(MatchFields(IgnoreExtras, Fields{"ClaimName": Equal("mysite-build-cache")})),
+				}),
+			})))
+			Expect(podSpec.InitContainers).Should(HaveLen(1))
+			purge := podSpec.InitContainers[0]
+			Expect(purge.Name).Should(Equal("purge-build-cache"))
+			Expect(purge.Env).Should(ContainElement(corev1.EnvVar{Name: "PURGE_TOKEN", Value: "1"}))
+			build := podSpec.Containers[0]
+			Expect(build.VolumeMounts).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("build-cache"), "MountPath": Equal(BuildCachePath)})))
+			Expect(build.Env).Should(ContainElement(corev1.EnvVar{Name: "XDG_CACHE_HOME", Value: BuildCachePath}))
+
+			By("removing the build cache")
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+			Expect(err).NotTo(HaveOccurred())
+			site.Spec.BuildCache = nil
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-cache"}, &pvc)
+				if apierrors.IsNotFound(err) {
+					return
+				}
+				g.Expect(err).NotTo(HaveOccurred())
+				// the PersistentVolumeClaim is protected by the finalizer while it is mounted
+				g.Expect(pvc.DeletionTimestamp).ShouldNot(BeNil())
+			}).Should(Succeed())
+		})
+
+		It("should not share the build cache between the replicas of nginx", func() {
+			site := newWebSite().withRawBuildScript().withReplicas(2).build()
+			site.Annotations = map[string]string{AnnPurgeBuildCache: "1"}
+			site.Spec.BuildCache = &websitev1beta1.BuildCacheStorage{}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &deployment)
+			}).Should(Succeed())
+			Expect(deployment.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("build-cache")})))
+			Expect(deployment.Spec.Template.Spec.InitContainers).Should(HaveLen(1))
+			Expect(deployment.Spec.Template.Spec.InitContainers[0].Name).Should(Equal("build"))
+
+			pvc := corev1.PersistentVolumeClaim{}
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-cache"}, &pvc)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+
+	Context("Artifacts", func() {
+		It("should build the site by Job before creating nginx Deployment", func() {
+			site := newWebSite().withRawBuildScript().withArtifacts().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc := corev1.PersistentVolumeClaim{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-artifacts"}, &pvc)
+			}).Should(Succeed())
+			Expect(pvc.Spec.AccessModes).Should(ConsistOf(corev1.ReadWriteMany))
+
+			jobs := batchv1.JobList{}
+			Eventually(func() ([]batchv1.Job, error) {
+				err := k8sClient.List(ctx, &jobs, client.InNamespace("test"), client.MatchingLabels{AppNameKey: AppNameBuild, InstanceKey: "mysite"})
+				return jobs.Items, err
+			}).Should(HaveLen(1))
+			job := jobs.Items[0]
+			Expect(job.Name).Should(HavePrefix("mysite-build-"))
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("artifacts")})))
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionBuildSucceeded), "Status": Equal(metav1.ConditionUnknown), "Reason": Equal(ReasonBuilding)}),
+				))
+			}).Should(Succeed())
+
+			deployment := appsv1.Deployment{}
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &deployment)
+			}, 3).ShouldNot(Succeed())
+		})
+
+		It("should reuse the cached artifact instead of building the revision again", func() {
+			site := newWebSite().withRawBuildScript().withArtifacts().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			buildRevision := func(rev string) batchv1.Job {
+				job := batchv1.Job{}
+				Eventually(func(g Gomega) {
+					jobs := batchv1.JobList{}
+					err := k8sClient.List(ctx, &jobs, client.InNamespace("test"), client.MatchingLabels{AppNameKey: AppNameBuild, InstanceKey: "mysite"})
+					g.Expect(err).NotTo(HaveOccurred())
+					found := false
+					for _, j := range jobs.Items {
+						if slices.Contains(j.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "REVISION", Value: rev}) {
+							job = j
+							found = true
+						}
+					}
+					g.Expect(found).Should(BeTrue())
+				}).Should(Succeed())
+
+				now := metav1.Now()
+				job.Status = batchv1.JobStatus{
+					StartTime:      &now,
+					CompletionTime: &now,
+					Succeeded:      1,
+					Conditions: []batchv1.JobCondition{
+						{Type: batchv1.JobSuccessCriteriaMet, Status: corev1.ConditionTrue, LastTransitionTime: now},
+						{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: now},
+					},
+				}
+				err := k8sClient.Status().Update(ctx, &job)
+				Expect(err).NotTo(HaveOccurred())
+				return job
+			}
+			servedArtifact := func() (string, error) {
+				deployment := appsv1.Deployment{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &deployment)
+				return deployment.Spec.Template.Annotations[AnnArtifact], err
+			}
+			updateRevision := func(rev string) {
+				mockClient.rev = rev
+				site := &websitev1beta1.WebSite{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				Expect(err).NotTo(HaveOccurred())
+				site.Annotations = map[string]string{"test": rev}
+				err = k8sClient.Update(ctx, site)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			job1 := buildRevision("rev1")
+			artifact1 := job1.Annotations[AnnArtifact]
+			Eventually(servedArtifact).Should(Equal(artifact1))
+
+			updateRevision("rev2")
+			job2 := buildRevision("rev2")
+			Expect(job2.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(corev1.EnvVar{Name: "RETAINED_ARTIFACTS", Value: artifact1}))
+			artifact2 := job2.Annotations[AnnArtifact]
+			Eventually(servedArtifact).Should(Equal(artifact2))
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Artifacts).Should(HaveLen(2))
+				g.Expect(site.Status.Artifacts[0].Name).Should(Equal(artifact2))
+				g.Expect(site.Status.Artifacts[1].Name).Should(Equal(artifact1))
+			}).Should(Succeed())
+
+			By("rolling back to the cached revision")
+			updateRevision("rev1")
+			Eventually(servedArtifact).Should(Equal(artifact1))
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: job1.Name}, &batchv1.Job{})
+			}, 3).ShouldNot(Succeed())
+		})
+
+		It("should retry the failed build when the annotation is changed", func() {
+			site := newWebSite().withRawBuildScript().withArtifacts().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			jobs := batchv1.JobList{}
+			Eventually(func() ([]batchv1.Job, error) {
+				err := k8sClient.List(ctx, &jobs, client.InNamespace("test"), client.MatchingLabels{AppNameKey: AppNameBuild, InstanceKey: "mysite"})
+				return jobs.Items, err
+			}).Should(HaveLen(1))
+			job := jobs.Items[0]
+
+			now := metav1.Now()
+			job.Status = batchv1.JobStatus{
+				StartTime: &now,
+				Failed:    1,
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded, LastTransitionTime: now},
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded, LastTransitionTime: now},
+				},
+			}
+			err = k8sClient.Status().Update(ctx, &job)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionBuildSucceeded), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonBuildFailed)}),
+				))
+			}).Should(Succeed())
+			Consistently(func(g Gomega) {
+				current := batchv1.Job{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: job.Name}, &current)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(current.UID).Should(Equal(job.UID))
+			}, 3).Should(Succeed())
+
+			By("changing the retry annotation")
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+			Expect(err).NotTo(HaveOccurred())
+			site.Annotations = map[string]string{AnnRetryBuild: "1"}
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			retried := batchv1.Job{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: job.Name}, &retried)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(retried.UID).ShouldNot(Equal(job.UID))
+			}).Should(Succeed())
+			Expect(retried.Annotations).Should(HaveKeyWithValue(AnnRetryBuild, "1"))
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionBuildSucceeded), "Status": Equal(metav1.ConditionUnknown), "Reason": Equal(ReasonBuilding)}),
+				))
+			}).Should(Succeed())
+		})
+	})
+
+	Context("BlueGreen", func() {
+		It("should switch Service after the new Deployment becomes ready and roll back on failure", func() {
+			site := newWebSite().withRawBuildScript().withStrategy(websitev1beta1.BlueGreenStrategy).build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			blue := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-blue"}, &blue)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			Expect(blue.Spec.Selector.MatchLabels).Should(HaveKeyWithValue(ColorKey, ColorBlue))
+			Expect(blue.Spec.Template.Spec.InitContainers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+
+			By("making the blue Deployment ready")
+			blue.Status = appsv1.DeploymentStatus{
+				ObservedGeneration: blue.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				AvailableReplicas:  1,
+				ReadyReplicas:      1,
+			}
+			err = k8sClient.Status().Update(ctx, &blue)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.ActiveColor).Should(Equal(ColorBlue))
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+
+				svc := corev1.Service{}
+				err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &svc)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(svc.Spec.Selector).Should(HaveKeyWithValue(ColorKey, ColorBlue))
+			}).Should(Succeed())
+
+			By("rolling out a new revision that cannot become ready")
+			mockClient.rev = "rev2"
+			site.Annotations = map[string]string{"test": "rev2"}
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			green := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-green"}, &green)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			Expect(green.Spec.Template.Spec.InitContainers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev2")})))
+
+			green.Status = appsv1.DeploymentStatus{
+				ObservedGeneration:  green.Generation,
+				Replicas:            1,
+				UnavailableReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{
+					{
+						Type:   appsv1.DeploymentProgressing,
+						Status: corev1.ConditionFalse,
+						Reason: "ProgressDeadlineExceeded",
+					},
+				},
+			}
+			err = k8sClient.Status().Update(ctx, &green)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.ActiveColor).Should(Equal(ColorBlue))
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionRolloutSucceeded), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonRolledBack)}),
+				))
+
+				err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-green"}, &green)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(green.Annotations).Should(HaveKey(AnnRolloutFailed))
+				g.Expect(*green.Spec.Replicas).Should(Equal(int32(0)))
+			}).Should(Succeed())
+		})
+
+		It("should not send the requests to the candidate before the first switch from RollingUpdate", func() {
+			site := newWebSite().withRawBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			markDeploymentAvailable("mysite")
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(site), site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Revision).Should(Equal("rev1"))
+			}).Should(Succeed())
+
+			By("switching to the BlueGreen strategy")
+			site.Spec.Strategy = websitev1beta1.BlueGreenStrategy
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			rolling := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &rolling)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(rolling.Spec.Template.Labels).Should(HaveKeyWithValue(ColorKey, ColorRollingUpdate))
+			}).Should(Succeed())
+			Consistently(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-blue"}, &appsv1.Deployment{})
+			}, 2).ShouldNot(Succeed())
+
+			By("making the labeled pods ready")
+			markDeploymentAvailable("mysite")
+			svc := corev1.Service{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &svc)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(svc.Spec.Selector).Should(HaveKeyWithValue(ColorKey, ColorRollingUpdate))
+				err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-blue"}, &appsv1.Deployment{})
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+
+			By("making the candidate ready")
+			markDeploymentAvailable("mysite-blue")
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &svc)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(svc.Spec.Selector).Should(HaveKeyWithValue(ColorKey, ColorBlue))
+				err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &rolling)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}).Should(Succeed())
+		})
+	})
+
+	Context("Previews", func() {
+		It("should create previews for pull requests", func() {
+			mockClient.pulls = []checker.PullRequest{
+				{Number: 3, Ref: "refs/pull/3/head", Revision: "pr3"},
+				{Number: 2, Ref: "refs/pull/2/head", Revision: "pr2"},
+			}
+			site := newWebSite().withRawBuildScript().withAfterBuildScript().withPreviews("pr-{{ .Number }}.{{ .ResourceName }}.example.com").build()
+			site.Spec.ExtraResources = []websitev1beta1.DataSource{
+				{RawData: ptr.To("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .ResourceName }}-extra\n")},
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			preview := websitev1beta1.WebSite{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3"}, &preview)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			Expect(preview.Labels).Should(HaveKeyWithValue(LabelPreviewOf, "mysite"))
+			Expect(preview.Annotations).Should(HaveKeyWithValue(AnnPreviewRef, "refs/pull/3/head"))
+			Expect(preview.OwnerReferences).Should(HaveLen(1))
+			Expect(preview.Spec.Revision).Should(Equal("pr3"))
+			Expect(preview.Spec.PublicURL).Should(Equal("https://pr-3.mysite.example.com/"))
+			Expect(preview.Spec.Previews).Should(BeNil())
+
+			By("checking the side effects of the parent are not copied to the preview")
+			Expect(preview.Spec.ExtraResources).Should(BeEmpty())
+			Expect(preview.Spec.AfterBuildScript).Should(BeNil())
+			cm := corev1.ConfigMap{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-extra"}, &cm)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3-extra"}, &cm)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+			By("checking the ref of the pull request is passed to the build")
+			dep := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3"}, &dep)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(dep.Spec.Template.Spec.InitContainers[0].Env).Should(ContainElement(corev1.EnvVar{Name: "PR_REF", Value: "refs/pull/3/head"}))
+			}).Should(Succeed())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Previews).Should(HaveLen(2))
+				g.Expect(site.Status.Previews[0].Name).Should(Equal("mysite-pr-3"))
+				g.Expect(site.Status.Previews[1].Name).Should(Equal("mysite-pr-2"))
+			}).Should(Succeed())
+
+			By("reducing the limit")
+			site.Spec.Previews.Limit = 1
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-2"}, &preview)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}).Should(Succeed())
+
+			By("checking the preview has no repo-checker")
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3-repo-checker"}, &dep)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("should not keep previews for the pull requests that are not updated for MaxAge", func() {
+			// GitHub keeps the refs of closed pull requests
+			now := time.Now()
+			mockClient.pulls = []checker.PullRequest{
+				{Number: 5, Ref: "refs/pull/5/head", Revision: "pr5", Timestamp: ptr.To(now.Add(-time.Hour))},
+				{Number: 4, Ref: "refs/pull/4/head", Revision: "pr4", Timestamp: ptr.To(now.Add(-48 * time.Hour))},
+				{Number: 3, Ref: "refs/pull/3/head", Revision: "pr3"},
+			}
+			site := newWebSite().withRawBuildScript().withPreviews("pr-{{ .Number }}.example.com").build()
+			site.Spec.Previews.MaxAge = &metav1.Duration{Duration: 24 * time.Hour}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Previews).Should(HaveLen(2))
+				g.Expect(site.Status.Previews[0].Number).Should(Equal(5))
+				g.Expect(site.Status.Previews[1].Number).Should(Equal(3))
+			}).Should(Succeed())
+			preview := websitev1beta1.WebSite{}
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-4"}, &preview)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+			By("closing the pull request")
+			mockClient.pulls[0].Timestamp = ptr.To(now.Add(-25 * time.Hour))
+			site.Spec.Previews.Limit = 4
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-5"}, &preview)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}).Should(Succeed())
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3"}, &preview)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should make previews that follow the BuildTemplate without the secrets", func() {
+			tmpl := &websitev1beta1.BuildTemplate{}
+			tmpl.Name = "npm-preview"
+			tmpl.Spec = websitev1beta1.BuildTemplateSpec{
+				BuildImage:  "ghcr.io/zoetrope/node:22.16.0",
+				BuildScript: &websitev1beta1.DataSource{RawData: ptr.To("#!/bin/bash\nnpm run build\n")},
+				Parameters: []websitev1beta1.BuildTemplateParameter{
+					{Name: "PARALLELISM", Default: ptr.To("2")},
+				},
+			}
+			err := k8sClient.Create(ctx, tmpl)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, tmpl)).To(Succeed())
+			})
+
+			mockClient.pulls = []checker.PullRequest{
+				{Number: 3, Ref: "refs/pull/3/head", Revision: "pr3"},
+			}
+			site := newWebSite().withBuildSecrets().withPreviews("pr-{{ .Number }}.example.com").build()
+			site.Spec.BuildImage = ""
+			site.Spec.BuildTemplate = &websitev1beta1.BuildTemplateRef{Name: "npm-preview"}
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			preview := websitev1beta1.WebSite{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3"}, &preview)
+				g.Expect(err).NotTo(HaveOccurred())
+			}).Should(Succeed())
+			Expect(preview.Spec.BuildImage).Should(BeEmpty())
+			Expect(preview.Spec.BuildScript.RawData).Should(BeNil())
+			Expect(preview.Spec.BuildTemplate).Should(Equal(&websitev1beta1.BuildTemplateRef{Name: "npm-preview"}))
+			Expect(preview.Spec.BuildSecrets).Should(BeEmpty())
+
+			By("updating the BuildTemplate")
+			tmpl.Spec.BuildImage = "ghcr.io/zoetrope/node:24.0.0"
+			err = k8sClient.Update(ctx, tmpl)
+			Expect(err).NotTo(HaveOccurred())
+			dep := appsv1.Deployment{}
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pr-3"}, &dep)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(dep.Spec.Template.Spec.InitContainers[0].Image).Should(Equal("ghcr.io/zoetrope/node:24.0.0"))
+			}).Should(Succeed())
+		})
+
+		It("should pass the secrets to previews only if they are inherited", func() {
+			parent := newWebSite().withBuildSecrets().withDeployKey().withPreviews("pr-{{ .Number }}.example.com").build()
+			parent.Spec.GitAuth = &websitev1beta1.GitAuth{}
+			pr := checker.PullRequest{Number: 3, Ref: "refs/pull/3/head", Revision: "pr3"}
+
+			spec := makePreviewSpec(&parent.Spec, pr, "https://pr-3.example.com/")
+			Expect(spec.BuildSecrets).Should(BeEmpty())
+			Expect(spec.DeployKeySecretName).Should(BeNil())
+			Expect(spec.GitAuth).Should(BeNil())
+
+			parent.Spec.Previews.InheritSecrets = true
+			spec = makePreviewSpec(&parent.Spec, pr, "https://pr-3.example.com/")
+			Expect(spec.BuildSecrets).Should(Equal(parent.Spec.BuildSecrets))
+			Expect(spec.DeployKeySecretName).Should(Equal(parent.Spec.DeployKeySecretName))
+			Expect(spec.GitAuth).ShouldNot(BeNil())
+		})
+	})
+
+	Context("BuildTemplate", func() {
+		It("should build the site by the BuildTemplate with the parameters", func() {
+			tmpl := &websitev1beta1.BuildTemplate{}
+			tmpl.Name = "npm-v1"
+			tmpl.Spec = websitev1beta1.BuildTemplateSpec{
+				BuildImage:  "ghcr.io/zoetrope/node:22.16.0",
+				BuildScript: &websitev1beta1.DataSource{RawData: ptr.To("#!/bin/bash\nnpm run build\n")},
+				Parameters: []websitev1beta1.BuildTemplateParameter{
+					{Name: "OUTPUT_DIR", Required: true},
+					{Name: "PARALLELISM", Type: websitev1beta1.ParameterTypeInteger, Default: ptr.To("2")},
+				},
+			}
+			err := k8sClient.Create(ctx, tmpl)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, tmpl)).To(Succeed())
+			})
+
+			site := newWebSite().build()
+			site.Spec.BuildImage = ""
+			site.Spec.BuildTemplate = &websitev1beta1.BuildTemplateRef{
+				Name:       "npm-v1",
+				Parameters: map[string]string{"OUTPUT_DIR": "build"},
+			}
+			err = k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := appsv1.Deployment{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+			}).Should(Succeed())
+			build := dep.Spec.Template.Spec.InitContainers[0]
+			Expect(build.Image).Should(Equal("ghcr.io/zoetrope/node:22.16.0"))
+			Expect(build.Env).Should(ContainElements(
+				corev1.EnvVar{Name: "OUTPUT_DIR", Value: "build"},
+				corev1.EnvVar{Name: "PARALLELISM", Value: "2"},
+			))
+			cm := corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-build-script"}, &cm)).To(Succeed())
+			Expect(cm.Data["build.sh"]).Should(ContainSubstring("npm run build"))
+
+			By("updating the BuildTemplate")
+			tmpl.Spec.BuildImage = "ghcr.io/zoetrope/node:24.0.0"
+			err = k8sClient.Update(ctx, tmpl)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &dep)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(dep.Spec.Template.Spec.InitContainers[0].Image).Should(Equal("ghcr.io/zoetrope/node:24.0.0"))
+			}).Should(Succeed())
+
+			By("giving an invalid parameter")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)).To(Succeed())
+			site.Spec.BuildTemplate.Parameters["PARALLELISM"] = "many"
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.Conditions).Should(ContainElement(
+					MatchFields(IgnoreExtras, Fields{"Type": Equal(websitev1beta1.ConditionConfigRendered), "Status": Equal(metav1.ConditionFalse), "Reason": Equal(ReasonBuildTemplateError)}),
+				))
+			}).Should(Succeed())
+		})
+
+		It("should resolve the parameters of the BuildTemplate", func() {
+			defs := []websitev1beta1.BuildTemplateParameter{
+				{Name: "OUTPUT_DIR", Required: true},
+				{Name: "MINIFY", Type: websitev1beta1.ParameterTypeBoolean, Default: ptr.To("true")},
+				{Name: "LOCALE"},
+			}
+
+			params, err := resolveParameters(defs, map[string]string{"OUTPUT_DIR": "_book"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).Should(Equal(map[string]string{"OUTPUT_DIR": "_book", "MINIFY": "true"}))
+
+			_, err = resolveParameters(defs, nil)
+			Expect(err).Should(MatchError(ContainSubstring("OUTPUT_DIR is required")))
+			_, err = resolveParameters(defs, map[string]string{"OUTPUT_DIR": "_book", "MINIFY": "yes"})
+			Expect(err).Should(MatchError(ContainSubstring("should be a boolean")))
+			_, err = resolveParameters(defs, map[string]string{"OUTPUT_DIR": "_book", "UNKNOWN": "x"})
+			Expect(err).Should(MatchError(ContainSubstring("unknown parameter UNKNOWN")))
+
+			_, err = resolveParameters([]websitev1beta1.BuildTemplateParameter{{Name: "REVISION"}}, map[string]string{"REVISION": "main"})
+			Expect(err).Should(MatchError(ContainSubstring("REVISION is reserved")))
+			_, err = resolveParameters([]websitev1beta1.BuildTemplateParameter{{Name: "GIT_CONFIG_COUNT", Default: ptr.To("0")}}, nil)
+			Expect(err).Should(MatchError(ContainSubstring("GIT_CONFIG_COUNT is reserved")))
+		})
+	})
+
+	Context("ExtraResources", func() {
+		It("should create extraResources", func() {
+			site := newWebSite().withRawBuildScript().withExtraResources().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			cm := corev1.ConfigMap{}
+			cm.Namespace = site.Namespace
+			cm.Name = "my-templates"
+			cm.Data = map[string]string{
+				"ubuntu": `apiVersion: v1
+kind: Pod
+metadata:
+  name: {{.ResourceName}}-ubuntu
+  namespace: unknown
+spec:
+  containers:
+  - name: ubuntu
+    image: ghcr.io/zoetrope/ubuntu:22.04
+    command: ["/usr/local/bin/pause"]
+`,
+			}
+			err = k8sClient.Create(ctx, &cm)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			pod := corev1.Pod{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: site.Namespace, Name: site.Name + "-ubuntu"}, &pod)
+			}).Should(Succeed())
+		})
+
+		It("should render multiple documents with the context and the functions", func() {
+			site := newWebSite().withRawBuildScript().build()
+			site.Labels = map[string]string{"team": "docs"}
+			site.Spec.PublicURL = "https://docs.example.com/guide/"
+			site.Spec.ExtraResources = []websitev1beta1.DataSource{
+				{
+					RawData: ptr.To(`# the site information
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .ResourceName }}-info
+  labels:
+    {{- toYaml .Labels | nindent 4 }}
+data:
+  host: {{ .Host | quote }}
+  path: {{ .Path | quote }}
+  revision: {{ .Revision | quote }}
+  branch: {{ .Spec.Branch | default "main" }}
+  tag: {{ .Spec.Tag | default "none" }}
+---
+{{- if .Spec.PublicURL }}
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .ResourceName }}-url
+data:
+  url: {{ b64enc .Spec.PublicURL }}
+{{- end }}
+---
+`),
+				},
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			cm := corev1.ConfigMap{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-info"}, &cm)
+			}).Should(Succeed())
+			Expect(cm.Labels).Should(HaveKeyWithValue("team", "docs"))
+			Expect(cm.Data).Should(Equal(map[string]string{
+				"host":     "docs.example.com",
+				"path":     "/guide/",
+				"revision": "rev1",
+				"branch":   "main",
+				"tag":      "none",
+			}))
+
+			secret := corev1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-url"}, &secret)
+			}).Should(Succeed())
+			Expect(string(secret.Data["url"])).Should(Equal("https://docs.example.com/guide/"))
+		})
+
+		It("should prune extraResources removed from the spec unless they are retained", func() {
+			configMap := func(name, annotations string) websitev1beta1.DataSource {
+				return websitev1beta1.DataSource{RawData: ptr.To(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .ResourceName }}-` + name + `
+  annotations: ` + annotations + `
+data:
+  name: ` + name + `
+`)}
+			}
+			site := newWebSite().withRawBuildScript().build()
+			site.Spec.ExtraResources = []websitev1beta1.DataSource{
+				configMap("kept", "{}"),
+				configMap("pruned", "{}"),
+				configMap("retained", `{"website.zoetrope.github.io/retain": "true"}`),
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.ExtraResources).Should(ConsistOf(
+					websitev1beta1.ExtraResourceRef{APIVersion: "v1", Kind: "ConfigMap", Name: "mysite-kept"},
+					websitev1beta1.ExtraResourceRef{APIVersion: "v1", Kind: "ConfigMap", Name: "mysite-pruned"},
+					websitev1beta1.ExtraResourceRef{APIVersion: "v1", Kind: "ConfigMap", Name: "mysite-retained"},
+				))
+			}).Should(Succeed())
+
+			site.Spec.ExtraResources = site.Spec.ExtraResources[:1]
+			err = k8sClient.Update(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, site)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(site.Status.ExtraResources).Should(ConsistOf(
+					websitev1beta1.ExtraResourceRef{APIVersion: "v1", Kind: "ConfigMap", Name: "mysite-kept"},
+				))
+			}).Should(Succeed())
+
+			cm := corev1.ConfigMap{}
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-kept"}, &cm)
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-pruned"}, &cm)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue(), "unexpected error: %v", err)
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite-retained"}, &cm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.OwnerReferences).Should(BeEmpty())
+		})
+
+		It("should take over the extraResources applied before the inventory was recorded", func() {
+			site := newWebSite().withRawBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			orphan := &corev1.ServiceAccount{}
+			orphan.Namespace = "test"
+			orphan.Name = "orphan"
+			Expect(ctrl.SetControllerReference(site, orphan, scheme)).To(Succeed())
+			err = k8sClient.Create(ctx, orphan)
+			Expect(err).NotTo(HaveOccurred())
+
+			own := &corev1.ConfigMap{}
+			own.Namespace = "test"
+			own.Name = "operator-own"
+			Expect(ctrl.SetControllerReference(site, own, scheme)).To(Succeed())
+			err = k8sClient.Create(ctx, own)
+			Expect(err).NotTo(HaveOccurred())
+
+			r := &WebSiteReconciler{
+				client:    k8sClient,
+				apiReader: k8sClient,
+				log:       ctrl.Log.WithName("controllers").WithName("WebSite"),
+				scheme:    scheme,
+				discovery: discovery.NewDiscoveryClientForConfigOrDie(cfg),
+			}
+
+			By("not looking up the resources of the WebSite that has recorded the inventory")
+			recorded := site.DeepCopy()
+			recorded.Status.Revision = "rev1"
+			setCondition(recorded, websitev1beta1.ConditionExtraResourcesApplied, metav1.ConditionTrue, ReasonApplied, "")
+			err = r.pruneExtraResources(ctx, recorded, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(orphan), orphan)).To(Succeed())
+
+			By("seeding the inventory of the WebSite served by the previous version")
+			site.Status.Revision = "rev1"
+			err = r.pruneExtraResources(ctx, site, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(site.Status.ExtraResources).Should(BeEmpty())
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(orphan), orphan)
+			Expect(apierrors.IsNotFound(err) || !orphan.DeletionTimestamp.IsZero()).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(own), own)).To(Succeed())
+			Expect(own.DeletionTimestamp.IsZero()).Should(BeTrue())
+		})
+	})
+
+	Context("AfterBuildcript", func() {
+		It("should create afterBuildScript job", func() {
+			site := newWebSite().withRawBuildScript().withAfterBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
+			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
+			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
+			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+		})
+
+		It("should create afterBuildScript job with the resources and the limits", func() {
+			site := newWebSite().withRawBuildScript().withAfterBuildScript().build()
+			site.Spec.AfterBuild = &websitev1beta1.AfterBuildSpec{
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+				},
+				BackoffLimit:          ptr.To[int32](1),
+				ActiveDeadlineSeconds: ptr.To[int64](300),
+			}
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Resources.Requests.Cpu().String()).Should(Equal("500m"))
+			Expect(job.Spec.BackoffLimit).Should(Equal(ptr.To[int32](1)))
+			Expect(job.Spec.ActiveDeadlineSeconds).Should(Equal(ptr.To[int64](300)))
+		})
+
+		It("should create afterBuildscript job with deploy key", func() {
+			site := newWebSite().withRawBuildScript().withDeployKey().withAfterBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
+			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
+			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
+			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+		})
+
+		It("should create afterBuildScirpt job with Image Secrets", func() {
+			site := newWebSite().withRawBuildScript().withImagePullSecrets().withAfterBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
+			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
+			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("VAR_KEY")})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
+			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("myimagepullsecret")})))
+		})
+
+		It("should create afterBuildscript job with Build Secrets", func() {
+			site := newWebSite().withRawBuildScript().withBuildSecrets().withAfterBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
+			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
+			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("VAR_KEY"), "ValueFrom": Equal(&corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mybuildsecret"}, Key: "VAR_KEY"}})})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
+			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
+			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+		})
+
+		It("should recreate afterBuildscript job when job exists and revision is updated ", func() {
+			site := newWebSite().withRawBuildScript().withAfterBuildScript().build()
+			err := k8sClient.Create(ctx, site)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := batchv1.Job{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "mysite"}, &job)
+			}).Should(Succeed())
+
+			Expect(job.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(job.Spec.Template.Labels).Should(HaveLen(4))
+			Expect(job.Spec.Template.Annotations).Should(HaveLen(1))
+			Expect(job.Spec.Template.Annotations).Should(HaveKey(AnnChecksumConfig))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("REVISION"), "Value": Equal("rev1")})))
+			Expect(job.Spec.Template.Spec.Volumes).Should(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("after-build-script")})))
+			Expect(job.Spec.Template.Spec.Volumes).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
 			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).ShouldNot(ContainElement(MatchFields(IgnoreExtras, Fields{"Name": Equal("deploy-key")})))
 			Expect(job.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
 
@@ -524,6 +2170,27 @@ type websiteBuilder struct {
 	website *websitev1beta1.WebSite
 }
 
+// markDeploymentAvailable updates the status of the Deployment as if all the replicas of the current generation are available.
+func markDeploymentAvailable(name string) {
+	Eventually(func(g Gomega) {
+		dep := appsv1.Deployment{}
+		err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "test", Name: name}, &dep)
+		g.Expect(err).NotTo(HaveOccurred())
+		dep.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: dep.Generation,
+			Replicas:           1,
+			UpdatedReplicas:    1,
+			AvailableReplicas:  1,
+			ReadyReplicas:      1,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+			},
+		}
+		err = k8sClient.Status().Update(context.Background(), &dep)
+		g.Expect(err).NotTo(HaveOccurred())
+	}).Should(Succeed())
+}
+
 func (b *websiteBuilder) build() *websitev1beta1.WebSite {
 	return b.website
 }
@@ -602,6 +2269,40 @@ func (b *websiteBuilder) withDeployKey() *websiteBuilder {
 	return b
 }
 
+func (b *websiteBuilder) withTag(tag string) *websiteBuilder {
+	b.website.Spec.Tag = tag
+	return b
+}
+
+func (b *websiteBuilder) withWebhookSecret() *websiteBuilder {
+	b.website.Spec.WebhookSecret = &websitev1beta1.SecretKey{
+		Name: "mywebhooksecret",
+		Key:  "token",
+	}
+	return b
+}
+
+func (b *websiteBuilder) withStrategy(strategy websitev1beta1.RolloutStrategy) *websiteBuilder {
+	b.website.Spec.Strategy = strategy
+	return b
+}
+
+func (b *websiteBuilder) withIngress(publicURL string) *websiteBuilder {
+	b.website.Spec.PublicURL = publicURL
+	b.website.Spec.Ingress = &websitev1beta1.IngressSpec{
+		IngressClassName: ptr.To("nginx"),
+		TLSSecretName:    "mysite-tls",
+	}
+	return b
+}
+
+func (b *websiteBuilder) withPreviews(host string) *websiteBuilder {
+	b.website.Spec.Previews = &websitev1beta1.PreviewsSpec{
+		Host: host,
+	}
+	return b
+}
+
 func (b *websiteBuilder) withImagePullSecrets() *websiteBuilder {
 	b.website.Spec.ImagePullSecrets = []corev1.LocalObjectReference{
 		{
@@ -654,6 +2355,13 @@ func (b *websiteBuilder) withServiceTemplate() *websiteBuilder {
 	return b
 }
 
+func (b *websiteBuilder) withArtifacts() *websiteBuilder {
+	b.website.Spec.Artifacts = &websitev1beta1.ArtifactsStorage{
+		Size: resource.MustParse("1Gi"),
+	}
+	return b
+}
+
 func (b *websiteBuilder) withExtraResources() *websiteBuilder {
 	b.website.Spec.ExtraResources = []websitev1beta1.DataSource{
 		{
@@ -11,7 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 )
 
 func testWebSite(name string) {
@@ -21,11 +21,14 @@ func testWebSite(name string) {
 		if err != nil {
 			return err
 		}
-		if site.Status.Ready != corev1.ConditionTrue {
+		if !meta.IsStatusConditionTrue(site.Status.Conditions, websitev1beta1.ConditionReady) {
 			return fmt.Errorf("%s should be ready", name)
 		}
+		if site.Status.ObservedGeneration != site.Generation {
+			return fmt.Errorf("%s should be observed", name)
+		}
 		return nil
-	}, 3*time.Minute).Should(Succeed())
+	}, 5*time.Minute).Should(Succeed())
 
 	var deployment appsv1.Deployment
 	Eventually(func() error {
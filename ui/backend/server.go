@@ -10,6 +10,7 @@ import (
 	"github.com/cybozu-go/website-operator/api/v1beta1"
 	"github.com/cybozu-go/website-operator/controllers"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -55,6 +56,8 @@ type website struct {
 	Name      string `json:"name"`
 	Status    string `json:"status"`
 	Revision  string `json:"revision"`
+	Author    string `json:"author,omitempty"`
+	Message   string `json:"message,omitempty"`
 	RepoURL   string `json:"repo"`
 	PublicURL string `json:"public"`
 	Branch    string `json:"branch"`
@@ -87,6 +90,10 @@ func (s apiServer) listWebSites(w http.ResponseWriter, r *http.Request) {
 			PublicURL: item.Spec.PublicURL,
 			Branch:    item.Spec.Branch,
 		}
+		if item.Status.Commit != nil {
+			resp[i].Author = item.Status.Commit.Author
+			resp[i].Message = item.Status.Commit.Message
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -100,7 +107,7 @@ func (s apiServer) listWebSites(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s apiServer) getStatus(r *http.Request, website v1beta1.WebSite) (string, error) {
-	if website.Status.Ready != corev1.ConditionTrue {
+	if !meta.IsStatusConditionTrue(website.Status.Conditions, v1beta1.ConditionReady) {
 		return "NotReady", nil
 	}
 
@@ -117,6 +124,9 @@ func (s apiServer) getStatus(r *http.Request, website v1beta1.WebSite) (string,
 	}
 
 	for _, pod := range pods.Items {
+		if pod.Labels["app.kubernetes.io/name"] == controllers.AppNameBuild {
+			continue
+		}
 		if pod.Status.Phase != corev1.PodRunning {
 			return string(pod.Status.Phase), nil
 		}
@@ -134,18 +144,24 @@ func (s apiServer) getBuildLog(w http.ResponseWriter, r *http.Request) {
 	ns := params[0]
 	resName := params[1]
 
+	// the site is built by a Job when artifacts are enabled, and by the init container of nginx otherwise
 	var pods corev1.PodList
-	err := s.kubeClient.List(r.Context(), &pods, &client.ListOptions{
-		LabelSelector: labels.SelectorFromSet(map[string]string{
-			"app.kubernetes.io/name":       controllers.AppNameNginx,
-			"app.kubernetes.io/instance":   resName,
-			"app.kubernetes.io/managed-by": "website-operator",
-		}),
-		Namespace: ns,
-	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, app := range []string{controllers.AppNameBuild, controllers.AppNameNginx} {
+		err := s.kubeClient.List(r.Context(), &pods, &client.ListOptions{
+			LabelSelector: labels.SelectorFromSet(map[string]string{
+				"app.kubernetes.io/name":       app,
+				"app.kubernetes.io/instance":   resName,
+				"app.kubernetes.io/managed-by": "website-operator",
+			}),
+			Namespace: ns,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(pods.Items) != 0 {
+			break
+		}
 	}
 	if len(pods.Items) == 0 {
 		http.Error(w, "not found", http.StatusNotFound)